@@ -8,8 +8,56 @@
  */
 package alfheimdbwal
 
+import "hash/crc32"
+
+const (
+	//Length 8Bytes + Index 8Bytes + Data
+	LOG_ITEM_VERSION_1 = 1
+	//Length 8Bytes + Index 8Bytes + CRC32C 4Bytes + Data
+	LOG_ITEM_VERSION_2 = 2
+	//same as version 2, batches are framed by control items
+	LOG_ITEM_VERSION_3 = 3
+	//new files are written with this version
+	LOG_ITEM_VERSION = LOG_ITEM_VERSION_3
+
+	LOG_ITEM_HEADER_LENGTH_V1 = 8 + 8
+	LOG_ITEM_HEADER_LENGTH_V2 = 8 + 8 + 4
+	LOG_ITEM_HEADER_LENGTH    = LOG_ITEM_HEADER_LENGTH_V2
+)
+
+const (
+	//the high bit of Length marks a control item written by the wal, it is not a log
+	LOG_ITEM_CONTROL_FLAG uint64 = 1 << 63
+	//control item data: Type 1Byte + Count 8Bytes
+	CONTROL_ITEM_DATA_LENGTH = 1 + 8
+	CONTROL_ITEM_LENGTH      = LOG_ITEM_HEADER_LENGTH + CONTROL_ITEM_DATA_LENGTH
+)
+
+type ControlType uint8
+
+//A batch part is framed by BATCH_BEGIN and BATCH_COMMIT or BATCH_CONTINUE.
+//The logs of a batch part without the end control item are dropped when the file is loaded.
+const (
+	BATCH_BEGIN ControlType = 1
+	//the batch is committed
+	BATCH_COMMIT ControlType = 2
+	//the batch part is committed, the batch continues in the next file
+	BATCH_CONTINUE ControlType = 3
+)
+
+//CRC32C (Castagnoli) table, the checksum covers length, index and data
+var Crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type LogItem struct {
 	Length uint64
 	Index  int64
 	Pos    uint64
 }
+
+//log item header length of the version
+func LogItemHeaderLength(version int) int64 {
+	if version == LOG_ITEM_VERSION_1 {
+		return LOG_ITEM_HEADER_LENGTH_V1
+	}
+	return LOG_ITEM_HEADER_LENGTH_V2
+}
@@ -0,0 +1,185 @@
+package raftlog
+
+import (
+	"errors"
+	"fmt"
+
+	alfheimdbwal "github.com/dj456119/AlfheimDB-WAL"
+)
+
+type LogType uint8
+
+const (
+	//a command applied to the state machine
+	LogCommand LogType = 0
+	//a no op entry written by a new leader
+	LogNoop LogType = 1
+	//a cluster membership change
+	LogConfiguration LogType = 2
+	//a barrier, it is applied after all entries before it
+	LogBarrier LogType = 3
+
+	//Term 8Bytes + Type 1Byte
+	LOG_HEADER_LENGTH = 8 + 1
+)
+
+var (
+	//The log index is not in the store
+	ErrLogNotFound = errors.New("raftlog: log not found")
+)
+
+//A raft log entry
+type Log struct {
+	Index uint64
+	Term  uint64
+	Type  LogType
+	Data  []byte
+}
+
+//Store is the log store a raft library uses, LogStore implements it with an AlfheimDBWAL.
+type Store interface {
+	//index of the first log, 0 if the store is empty
+	FirstIndex() (uint64, error)
+	//index of the last log, 0 if the store is empty
+	LastIndex() (uint64, error)
+	//read the log of index to log, ErrLogNotFound if it is not in the store
+	GetLog(index uint64, log *Log) error
+	StoreLog(log *Log) error
+	//store the logs in one batch, they are stored or not as a whole
+	StoreLogs(logs []*Log) error
+	//remove the logs [min, max]
+	DeleteRange(min, max uint64) error
+}
+
+//The wal log data of an entry:
+//┌──────────────┬─────────────┬─────────────┐
+//│ Term 8Bytes  │ Type 1Byte  │    Data     │
+//└──────────────┴─────────────┴─────────────┘
+//The log index is the wal log index.
+type LogStore struct {
+	WAL *alfheimdbwal.AlfheimDBWAL
+}
+
+//Open the wal in dir as a log store
+func Open(dir string, opts alfheimdbwal.Options) (*LogStore, error) {
+	wal, err := alfheimdbwal.NewWALWithOptions(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogStore(wal), nil
+}
+
+func NewLogStore(wal *alfheimdbwal.AlfheimDBWAL) *LogStore {
+	return &LogStore{WAL: wal}
+}
+
+func (s *LogStore) FirstIndex() (uint64, error) {
+	first, _, err := s.indexes()
+	return first, err
+}
+
+func (s *LogStore) LastIndex() (uint64, error) {
+	_, last, err := s.indexes()
+	return last, err
+}
+
+//The range of the logs. An empty wal reports the next index after a Reset or a delete of all logs,
+//the store reports 0 for both as raft expects.
+func (s *LogStore) indexes() (uint64, uint64, error) {
+	first, err := s.WAL.FirstIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := s.WAL.LastIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	if last < first {
+		return 0, 0, nil
+	}
+	return uint64(first), uint64(last), nil
+}
+
+func (s *LogStore) GetLog(index uint64, log *Log) error {
+	data, err := s.WAL.GetLog(int64(index))
+	if errors.Is(err, alfheimdbwal.ErrNotFound) {
+		return ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return DecodeLog(int64(index), data, log)
+}
+
+func (s *LogStore) StoreLog(log *Log) error {
+	return s.StoreLogs([]*Log{log})
+}
+
+func (s *LogStore) StoreLogs(logs []*Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	size := 0
+	for _, log := range logs {
+		size = size + alfheimdbwal.LOG_ITEM_HEADER_LENGTH + LOG_HEADER_LENGTH + len(log.Data)
+	}
+	buff := make([]byte, size)
+	lItems := make([]*alfheimdbwal.LogItem, len(logs))
+	pos := 0
+	for i, log := range logs {
+		data := buff[pos+alfheimdbwal.LOG_ITEM_HEADER_LENGTH : pos+alfheimdbwal.LOG_ITEM_HEADER_LENGTH+LOG_HEADER_LENGTH+len(log.Data)]
+		EncodeLog(log, data)
+		lItems[i] = alfheimdbwal.NewLogItemBuff(int64(log.Index), data, buff[pos:], true)
+		pos = pos + alfheimdbwal.LOG_ITEM_HEADER_LENGTH + len(data)
+	}
+	return s.WAL.BatchWriteLog(lItems, buff)
+}
+
+//Remove the logs [min, max]. Raft removes a prefix after a snapshot, or a suffix on a conflict,
+//they are TruncateFront and TruncateBack, a range in the middle is TruncateLog.
+//The store is written by one goroutine, the range is not changed by others while deleting.
+func (s *LogStore) DeleteRange(min, max uint64) error {
+	first, err := s.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := s.LastIndex()
+	if err != nil {
+		return err
+	}
+	if last == 0 || min > max || max < first || min > last {
+		return nil
+	}
+	switch {
+	case min <= first && max >= last:
+		return s.WAL.TruncateLog(int64(first), int64(last))
+	case min <= first:
+		return s.WAL.TruncateFront(int64(max + 1))
+	case max >= last:
+		return s.WAL.TruncateBack(int64(min - 1))
+	}
+	return s.WAL.TruncateLog(int64(min), int64(max))
+}
+
+func (s *LogStore) Close() error {
+	return s.WAL.Close()
+}
+
+//buff must have LOG_HEADER_LENGTH + len(log.Data) bytes
+func EncodeLog(log *Log, buff []byte) {
+	alfheimdbwal.WriteInt64ToBuff(buff, int64(log.Term), true)
+	buff[8] = byte(log.Type)
+	copy(buff[LOG_HEADER_LENGTH:], log.Data)
+}
+
+//Decode the wal log data of index to log, log.Data is a part of data
+func DecodeLog(index int64, data []byte, log *Log) error {
+	if len(data) < LOG_HEADER_LENGTH {
+		return fmt.Errorf("%w: raft log %d is %d bytes, less than %d", alfheimdbwal.ErrCorrupt, index, len(data), LOG_HEADER_LENGTH)
+	}
+	log.Index = uint64(index)
+	log.Term = alfheimdbwal.ReadInt64FromBuff(data, true)
+	log.Type = LogType(data[8])
+	log.Data = data[LOG_HEADER_LENGTH:]
+	return nil
+}
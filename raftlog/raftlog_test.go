@@ -0,0 +1,52 @@
+package raftlog_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	alfheimdbwal "github.com/dj456119/AlfheimDB-WAL"
+	"github.com/dj456119/AlfheimDB-WAL/raftlog"
+	"github.com/dj456119/AlfheimDB-WAL/raftlog/raftlogtest"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogStore(t *testing.T) {
+	//small segments, the cases cross the file boundaries
+	for _, items := range []int64{1000, 7, 1} {
+		for _, strict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d logs per file, strict index %v", items, strict), func(t *testing.T) {
+				testLogStore(t, items, strict)
+			})
+		}
+	}
+}
+
+func testLogStore(t *testing.T, items int64, strict bool) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	opts := alfheimdbwal.DefaultOptions()
+	opts.SegmentMaxItems = items
+	opts.StrictIndex = strict
+	opts.Logger = logger
+	suite := raftlogtest.Suite{
+		New: func() (raftlog.Store, error) {
+			return raftlog.Open(t.TempDir(), opts)
+		},
+		Reopen: func(store raftlog.Store) (raftlog.Store, error) {
+			s := store.(*raftlog.LogStore)
+			err := s.Close()
+			if err != nil {
+				return nil, err
+			}
+			return raftlog.Open(s.WAL.Dirname, opts)
+		},
+		Close: func(store raftlog.Store) error {
+			return store.(*raftlog.LogStore).Close()
+		},
+	}
+	err := suite.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,300 @@
+package raftlogtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dj456119/AlfheimDB-WAL/raftlog"
+)
+
+//Conformance cases of a raftlog.Store.
+//New returns an empty store for each case, Reopen closes the store and opens it again,
+//nil Reopen skips the durability cases, Close closes the store after each case, it may be nil.
+//
+//	suite := raftlogtest.Suite{New: newStore, Reopen: reopen, Close: closeStore}
+//	if err := suite.Run(); err != nil {
+//		t.Fatal(err)
+//	}
+type Suite struct {
+	New    func() (raftlog.Store, error)
+	Reopen func(store raftlog.Store) (raftlog.Store, error)
+	Close  func(store raftlog.Store) error
+}
+
+type testCase struct {
+	name string
+	run  func(suite *Suite, store raftlog.Store) (raftlog.Store, error)
+}
+
+var testCases = []testCase{
+	{"empty store", testEmpty},
+	{"store and get a log", testStoreLog},
+	{"store logs in a batch", testStoreLogs},
+	{"delete a prefix", testDeletePrefix},
+	{"delete a suffix and store again", testDeleteSuffix},
+	{"delete all and store after a snapshot", testDeleteAll},
+	{"big log", testBigLog},
+	{"logs are durable", testReopen},
+}
+
+//Run all cases, the first failed case is returned
+func (suite *Suite) Run() error {
+	for _, c := range testCases {
+		store, err := suite.New()
+		if err != nil {
+			return fmt.Errorf("%s: new store: %w", c.name, err)
+		}
+		store, err = c.run(suite, store)
+		if suite.Close != nil && store != nil {
+			closeErr := suite.Close(store)
+			if err == nil && closeErr != nil {
+				err = fmt.Errorf("close store: %w", closeErr)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+func newLog(index, term uint64) *raftlog.Log {
+	return &raftlog.Log{Index: index, Term: term, Type: raftlog.LogType(index % 4), Data: []byte(fmt.Sprintf("log-%d-%d", index, term))}
+}
+
+func newLogs(first, last, term uint64) []*raftlog.Log {
+	logs := make([]*raftlog.Log, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		logs = append(logs, newLog(i, term))
+	}
+	return logs
+}
+
+//the store has the logs [first, last] of term, and only them
+func checkRange(store raftlog.Store, first, last, term uint64) error {
+	err := checkIndexes(store, first, last)
+	if err != nil {
+		return err
+	}
+	if last == 0 {
+		return checkNotFound(store, 1)
+	}
+	for _, want := range newLogs(first, last, term) {
+		err = checkLog(store, want)
+		if err != nil {
+			return err
+		}
+	}
+	if first > 1 {
+		err = checkNotFound(store, first-1)
+		if err != nil {
+			return err
+		}
+	}
+	return checkNotFound(store, last+1)
+}
+
+func checkIndexes(store raftlog.Store, first, last uint64) error {
+	index, err := store.FirstIndex()
+	if err != nil {
+		return fmt.Errorf("first index: %w", err)
+	}
+	if index != first {
+		return fmt.Errorf("first index is %d, want %d", index, first)
+	}
+	index, err = store.LastIndex()
+	if err != nil {
+		return fmt.Errorf("last index: %w", err)
+	}
+	if index != last {
+		return fmt.Errorf("last index is %d, want %d", index, last)
+	}
+	return nil
+}
+
+func checkLog(store raftlog.Store, want *raftlog.Log) error {
+	log := new(raftlog.Log)
+	err := store.GetLog(want.Index, log)
+	if err != nil {
+		return fmt.Errorf("get log %d: %w", want.Index, err)
+	}
+	if log.Index != want.Index || log.Term != want.Term || log.Type != want.Type || !bytes.Equal(log.Data, want.Data) {
+		return fmt.Errorf("log %d is {%d %d %d %d bytes}, want {%d %d %d %d bytes}", want.Index,
+			log.Index, log.Term, log.Type, len(log.Data), want.Index, want.Term, want.Type, len(want.Data))
+	}
+	return nil
+}
+
+func checkNotFound(store raftlog.Store, index uint64) error {
+	err := store.GetLog(index, new(raftlog.Log))
+	if !errors.Is(err, raftlog.ErrLogNotFound) {
+		return fmt.Errorf("get log %d returns %v, want %v", index, err, raftlog.ErrLogNotFound)
+	}
+	return nil
+}
+
+func testEmpty(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	return store, checkRange(store, 0, 0, 0)
+}
+
+func testStoreLog(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	err := store.StoreLog(newLog(1, 1))
+	if err != nil {
+		return store, err
+	}
+	//a log without data, such as a no op log
+	err = store.StoreLog(&raftlog.Log{Index: 2, Term: 1, Type: raftlog.LogNoop})
+	if err != nil {
+		return store, err
+	}
+	err = checkIndexes(store, 1, 2)
+	if err != nil {
+		return store, err
+	}
+	err = checkLog(store, newLog(1, 1))
+	if err != nil {
+		return store, err
+	}
+	return store, checkLog(store, &raftlog.Log{Index: 2, Term: 1, Type: raftlog.LogNoop})
+}
+
+func testStoreLogs(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	err := store.StoreLogs(newLogs(1, 10, 1))
+	if err != nil {
+		return store, err
+	}
+	err = store.StoreLogs(newLogs(11, 30, 1))
+	if err != nil {
+		return store, err
+	}
+	return store, checkRange(store, 1, 30, 1)
+}
+
+func testDeletePrefix(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	err := store.StoreLogs(newLogs(1, 30, 1))
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(1, 10)
+	if err != nil {
+		return store, err
+	}
+	err = checkRange(store, 11, 30, 1)
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(11, 29)
+	if err != nil {
+		return store, err
+	}
+	return store, checkRange(store, 30, 30, 1)
+}
+
+func testDeleteSuffix(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	err := store.StoreLogs(newLogs(1, 30, 1))
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(21, 30)
+	if err != nil {
+		return store, err
+	}
+	err = checkRange(store, 1, 20, 1)
+	if err != nil {
+		return store, err
+	}
+	//the conflicting logs are replaced by the logs of the new leader
+	err = store.StoreLogs(newLogs(21, 25, 2))
+	if err != nil {
+		return store, err
+	}
+	err = checkIndexes(store, 1, 25)
+	if err != nil {
+		return store, err
+	}
+	err = checkLog(store, newLog(20, 1))
+	if err != nil {
+		return store, err
+	}
+	return store, checkLog(store, newLog(21, 2))
+}
+
+func testDeleteAll(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	err := store.StoreLogs(newLogs(1, 30, 1))
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(1, 30)
+	if err != nil {
+		return store, err
+	}
+	err = checkRange(store, 0, 0, 0)
+	if err != nil {
+		return store, err
+	}
+	if suite.Reopen != nil {
+		store, err = suite.Reopen(store)
+		if err != nil {
+			return nil, fmt.Errorf("reopen: %w", err)
+		}
+		err = checkRange(store, 0, 0, 0)
+		if err != nil {
+			return store, err
+		}
+	}
+	//a snapshot at 100 is installed, the logs start after it
+	err = store.StoreLogs(newLogs(101, 110, 3))
+	if err != nil {
+		return store, err
+	}
+	return store, checkRange(store, 101, 110, 3)
+}
+
+func testBigLog(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	log := &raftlog.Log{Index: 1, Term: 1, Type: raftlog.LogCommand, Data: bytes.Repeat([]byte("alfheimdb"), 1<<17)}
+	err := store.StoreLog(log)
+	if err != nil {
+		return store, err
+	}
+	return store, checkLog(store, log)
+}
+
+func testReopen(suite *Suite, store raftlog.Store) (raftlog.Store, error) {
+	if suite.Reopen == nil {
+		return store, nil
+	}
+	err := store.StoreLogs(newLogs(1, 30, 1))
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(1, 5)
+	if err != nil {
+		return store, err
+	}
+	err = store.DeleteRange(26, 30)
+	if err != nil {
+		return store, err
+	}
+	store, err = suite.Reopen(store)
+	if err != nil {
+		return nil, fmt.Errorf("reopen: %w", err)
+	}
+	err = checkRange(store, 6, 25, 1)
+	if err != nil {
+		return store, err
+	}
+	err = store.StoreLogs(newLogs(26, 40, 2))
+	if err != nil {
+		return store, err
+	}
+	store, err = suite.Reopen(store)
+	if err != nil {
+		return nil, fmt.Errorf("reopen: %w", err)
+	}
+	err = checkIndexes(store, 6, 40)
+	if err != nil {
+		return store, err
+	}
+	return store, checkLog(store, newLog(40, 2))
+}
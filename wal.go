@@ -9,9 +9,9 @@
 package alfheimdbwal
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,51 +27,205 @@ type AlfheimDBWAL struct {
 	AFiles      map[int64]*AlfheimDBWALFile
 	MinIndex    int64
 	MaxIndex    int64
-	MaxItems    int64
 	Dirname     string
 	IsBigEndian bool
-	Mutex       *sync.Mutex
+	//guards the index, readers share it, writers hold it to write and index logs, not to fsync
+	Mutex *sync.RWMutex
+	//what the last BuildDirIndex repaired
+	RecoveryReport *RecoveryReport
+	Options        Options
+	Meta           *WALMeta
+	Logger         logrus.FieldLogger
+	//held until Close
+	DirLock *DirLock
+
+	syncStop    chan struct{}
+	compactStop chan struct{}
+	commitChan  chan *CommitRequest
+	commitStop  chan struct{}
+	//closed when the group committer exits
+	commitDone chan struct{}
+	wg         sync.WaitGroup
+	stopOnce   sync.Once
+	//set by Close, guarded by writeMutex and Mutex
+	closed bool
+	//one writer appends, truncates or syncs at a time
+	writeMutex sync.Mutex
+	//wakes the subscriptions
+	durable durableNotifier
+	//values of the stable store
+	stable stableValues
+}
+
+type RecoveryReport struct {
+	TruncatedFiles []*TruncatedFile
+}
+
+//torn bytes dropped from a file
+type TruncatedFile struct {
+	Filename string
+	//the file is truncated to Offset
+	Offset         int64
+	TruncatedBytes int64
+	//no whole log item left, the file is removed
+	Removed bool
+}
+
+func NewWAL(waldir string) (*AlfheimDBWAL, error) {
+	return NewWALWithOptions(waldir, DefaultOptions())
 }
 
-func NewWAL(waldir string) *AlfheimDBWAL {
+func NewWALWithOptions(waldir string, opts Options) (*AlfheimDBWAL, error) {
+	return newWAL(waldir, opts, true)
+}
+
+//Open the wal to read, for inspection and backup, the options are loaded from the wal dir.
+//It never changes the dir and takes no lock, so it can open a wal used by a live writer,
+//the logs are the ones in the files when it is opened. Writes and truncates return ErrReadOnly.
+func OpenReadOnly(waldir string) (*AlfheimDBWAL, error) {
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	meta, err := ReadMeta(waldir)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		opts.HeaderLength = meta.HeaderLength
+	}
+	return newWAL(waldir, opts, false)
+}
+
+//lockDir is false only for OpenReadOnly
+func newWAL(waldir string, opts Options, lockDir bool) (*AlfheimDBWAL, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
 	wal := new(AlfheimDBWAL)
 	wal.Dirname = waldir
-	wal.MaxItems = 1000
 	wal.IsBigEndian = true
-	wal.Mutex = new(sync.Mutex)
-	wal.BuildDirIndex()
-	return wal
+	wal.Options = opts
+	wal.Logger = opts.Logger
+	wal.Mutex = new(sync.RWMutex)
+	if lockDir {
+		wal.DirLock, err = LockDir(waldir, opts.ReadOnly, opts.FileMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = wal.LoadStable()
+	if err != nil {
+		wal.DirLock.Unlock()
+		return nil, err
+	}
+	err = wal.BuildDirIndex()
+	if err != nil {
+		wal.DirLock.Unlock()
+		return nil, err
+	}
+	//the logs loaded from files are durable
+	wal.durable.durableIndex = wal.MaxIndex
+	wal.durable.notify = make(chan struct{})
+	wal.durable.subs = make(map[*Subscription]struct{})
+	if opts.ReadOnly {
+		return wal, nil
+	}
+	wal.StartSyncLoop()
+	wal.StartGroupCommit()
+	wal.StartCompactLoop()
+	return wal, nil
 }
 
 //Range dir build log file index
-func (wal *AlfheimDBWAL) BuildDirIndex() {
+func (wal *AlfheimDBWAL) BuildDirIndex() error {
 	sList := skiplist.New(skiplist.Int64)
 	fileMap := make(map[int64]*AlfheimDBWALFile)
 	files, err := ioutil.ReadDir(wal.Dirname)
 	if err != nil {
-		logrus.Fatal("Read wal dir error, ", err)
+		return NewIOError("readdir", wal.Dirname, err)
 	}
 
-	aFileChan := make(chan *AlfheimDBWALFile)
-	matchCount := 0
+	logFiles := make([]string, 0, len(files))
 	for _, file := range files {
+		if file.Name() == META_FILENAME || file.Name() == LOCK_FILENAME || file.Name() == STABLE_FILENAME {
+			continue
+		}
+		//a rewrite not renamed before crash, the file it rewrites is still there
+		if strings.HasPrefix(file.Name(), REWRITE_FILE_PREFIX) {
+			if wal.Options.ReadOnly {
+				continue
+			}
+			wal.Logger.Info("Remove unfinished rewrite file: ", file.Name())
+			err = os.Remove(filepath.Join(wal.Dirname, file.Name()))
+			if err != nil {
+				return NewIOError("remove", filepath.Join(wal.Dirname, file.Name()), err)
+			}
+			continue
+		}
 		if !strings.HasPrefix(file.Name(), "log") {
-			logrus.Info("No match file name: ", file.Name())
+			wal.Logger.Info("No match file name: ", file.Name())
 			continue
 		}
-		matchCount++
-		go GoFuncNewAlfheimDBWALFile(filepath.Join(wal.Dirname, file.Name()), sList, fileMap, aFileChan)
+		logFiles = append(logFiles, filepath.Join(wal.Dirname, file.Name()))
+	}
+	err = wal.LoadMeta(len(logFiles) != 0)
+	if err != nil {
+		return err
+	}
+	logFiles, err = wal.FinishReset(logFiles)
+	if err != nil {
+		return err
 	}
 
-	for i := 0; i != matchCount; {
-		aFile := <-aFileChan
-		i++
+	aFileChan := make(chan *AlfheimDBWALFile)
+	errChan := make(chan error)
+	matchCount := len(logFiles)
+	for _, filename := range logFiles {
+		go GoFuncNewAlfheimDBWALFile(filename, &wal.Options, aFileChan, errChan)
+	}
+
+	aFiles := make([]*AlfheimDBWALFile, 0, matchCount)
+	for i := 0; i != matchCount; i++ {
+		select {
+		case aFile := <-aFileChan:
+			aFiles = append(aFiles, aFile)
+		case e := <-errChan:
+			//a live writer may remove files after they are listed
+			if wal.Options.ReadOnly && errors.Is(e, os.ErrNotExist) {
+				wal.Logger.Info("File is removed, skip: ", e)
+				continue
+			}
+			wal.Logger.Error("Init wal file error, ", e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	if err != nil {
+		CloseAllFiles(aFiles)
+		return err
+	}
+
+	report := new(RecoveryReport)
+	for _, aFile := range aFiles {
 		if aFile.LogIndex.Len() == 0 {
-			logrus.Info("File is empty, remove: ", aFile.Filename)
+			if wal.Options.ReadOnly {
+				wal.Logger.Info("File is empty, skip: ", aFile.Filename)
+				aFile.Close()
+				continue
+			}
+			wal.Logger.Info("File is empty, remove: ", aFile.Filename)
 			aFile.Close()
 			err := os.Remove(aFile.Filename)
 			if err != nil {
-				log.Fatal("Init file remove error, ", err)
+				CloseAllFiles(aFiles)
+				return NewIOError("remove", aFile.Filename, err)
+			}
+			if aFile.TornBytes > 0 {
+				report.TruncatedFiles = append(report.TruncatedFiles, &TruncatedFile{Filename: aFile.Filename, Offset: aFile.Pos, TruncatedBytes: aFile.TornBytes, Removed: true})
 			}
 			continue
 		}
@@ -79,102 +233,544 @@ func (wal *AlfheimDBWAL) BuildDirIndex() {
 		fileMap[aFile.MinIndex] = aFile
 	}
 
+	err = wal.RepairTail(sList, fileMap, report)
+	if err != nil {
+		CloseAllFiles(aFiles)
+		return err
+	}
+
 	wal.Mutex.Lock()
 	wal.MinIndex = -1
 	wal.MaxIndex = 0
 	wal.FileIndex = sList
 	wal.AFiles = fileMap
+	wal.RecoveryReport = report
 	wal.RefreshAllMinAndMaxIndex()
 	wal.Mutex.Unlock()
-	return
+	return nil
 }
 
-func GoFuncNewAlfheimDBWALFile(filename string, sList *skiplist.SkipList, fileMap map[int64]*AlfheimDBWALFile, aFileChan chan *AlfheimDBWALFile) {
-	aFile := NewAlfheimDBWALFile(filename)
-	aFileChan <- aFile
+//A torn write can only be at the tail of the last file, truncate it.
+//Dirty bytes in other files mean the file is corrupt.
+//A read only wal keeps the torn bytes, they may be written by a live writer.
+func (wal *AlfheimDBWAL) RepairTail(sList *skiplist.SkipList, fileMap map[int64]*AlfheimDBWALFile, report *RecoveryReport) error {
+	for elem := sList.Front(); elem != nil; elem = elem.Next() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.TornBytes == 0 {
+			continue
+		}
+		if elem.Next() != nil {
+			return NewCorruptError(aFile.Filename, aFile.Pos, fmt.Sprintf("%d dirty bytes in a sealed file", aFile.TornBytes))
+		}
+		if wal.Options.ReadOnly {
+			wal.Logger.Warnf("Skip %d torn bytes at %d of %s", aFile.TornBytes, aFile.Pos, aFile.Filename)
+			return nil
+		}
+		report.TruncatedFiles = append(report.TruncatedFiles, &TruncatedFile{Filename: aFile.Filename, Offset: aFile.Pos, TruncatedBytes: aFile.TornBytes})
+		err := aFile.RepairTail()
+		if err != nil {
+			return err
+		}
+	}
+
+	//A batch split across files is committed by its last part.
+	//If the last file ends with a continued batch part, the rest is lost, drop the part,
+	//the file may be empty then, and the file before it may end with a part of the same batch.
+	for sList.Len() != 0 {
+		elem := sList.Back()
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.ContinuedPos == -1 {
+			break
+		}
+		if wal.Options.ReadOnly {
+			wal.Logger.Warnf("Batch at %d of %s is not committed", aFile.ContinuedPos, aFile.Filename)
+			break
+		}
+		truncated := &TruncatedFile{Filename: aFile.Filename, Offset: aFile.ContinuedPos, TruncatedBytes: aFile.Pos - aFile.ContinuedPos}
+		report.TruncatedFiles = append(report.TruncatedFiles, truncated)
+		err := aFile.DropContinuedBatch()
+		if err != nil {
+			return err
+		}
+		if aFile.LogIndex.Len() != 0 {
+			break
+		}
+		truncated.Removed = true
+		sList.Remove(elem.Key())
+		delete(fileMap, elem.Key().(int64))
+		err = wal.RemoveFile(aFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	//a TruncateBack may cut the last file before the truncate areas after the cut are dropped
+	if sList.Len() != 0 && !wal.Options.ReadOnly {
+		return sList.Back().Value.(*AlfheimDBWALFile).ClipTruncateAreas()
+	}
+	return nil
 }
 
-//write single log
-func (wal *AlfheimDBWAL) WriteLog(lItem *LogItem, data []byte) {
+//Stop the background goroutines, fsync and close all files.
+//Every method returns ErrClosed after Close, the blocked subscriptions wake up with ErrClosed.
+func (wal *AlfheimDBWAL) Close() error {
+	wal.stopOnce.Do(wal.StopBackground)
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	var err error
+	if !wal.Options.ReadOnly {
+		err = wal.SyncFiles()
+	}
 	wal.Mutex.Lock()
-	defer wal.Mutex.Unlock()
+	wal.closed = true
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		closeErr := elem.Value.(*AlfheimDBWALFile).Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	wal.Mutex.Unlock()
+	wal.CloseDurable()
+	unlockErr := wal.DirLock.Unlock()
+	if err == nil {
+		err = unlockErr
+	}
+	return err
+}
 
-	if lItem == nil || len(data) == 0 {
-		logrus.Warn("Empty logs written.")
-		return
+//stop the sync loop, the group committer and the compaction, wait for them to exit
+func (wal *AlfheimDBWAL) StopBackground() {
+	if wal.syncStop != nil {
+		close(wal.syncStop)
 	}
-	if wal.FileIndex.Len() == 0 || wal.FileIndex.Back().Value.(*AlfheimDBWALFile).LogIndex.Len() >= int(wal.MaxItems) {
-		aFile := wal.CreateNewFile(lItem.Index)
-		aFile.WriteLog(lItem, data)
-		wal.FileIndex.Set(aFile.MinIndex, aFile)
-		wal.AFiles[aFile.MinIndex] = aFile
-		wal.RefreshMinAndMaxIndex(aFile)
+	if wal.compactStop != nil {
+		close(wal.compactStop)
+	}
+	if wal.commitStop != nil {
+		close(wal.commitStop)
+	}
+	wal.wg.Wait()
+}
+
+func CloseAllFiles(aFiles []*AlfheimDBWALFile) {
+	for _, aFile := range aFiles {
+		aFile.Close()
+	}
+}
+
+func GoFuncNewAlfheimDBWALFile(filename string, opts *Options, aFileChan chan *AlfheimDBWALFile, errChan chan error) {
+	aFile, err := NewAlfheimDBWALFile(filename, opts)
+	if err != nil {
+		errChan <- err
 		return
 	}
+	aFileChan <- aFile
+}
 
-	elem := wal.FileIndex.Back()
-	aFile := elem.Value.(*AlfheimDBWALFile)
-	aFile.WriteLog(lItem, data)
-	wal.RefreshMinAndMaxIndex(aFile)
-	return
+//write single log
+func (wal *AlfheimDBWAL) WriteLog(lItem *LogItem, data []byte) error {
+	if lItem == nil || len(data) == 0 {
+		wal.Logger.Warn("Empty logs written.")
+		return nil
+	}
+	return wal.BatchWriteLog([]*LogItem{lItem}, data)
 }
 
 //batch write log
-func (wal *AlfheimDBWAL) BatchWriteLog(lItems []*LogItem, data []byte) {
+func (wal *AlfheimDBWAL) BatchWriteLog(lItems []*LogItem, data []byte) error {
+	if len(lItems) == 0 || len(data) == 0 {
+		wal.Logger.Warn("Empty logs written.")
+		return nil
+	}
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	if wal.Options.SyncPolicy == SyncGroupCommit {
+		return wal.GroupCommit(lItems, data)
+	}
+
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	if wal.Options.StrictIndex {
+		err := CheckAppendIndex(lItems, wal.NextAppendIndex())
+		if err != nil {
+			return err
+		}
+	}
 	wal.Mutex.Lock()
-	defer wal.Mutex.Unlock()
+	err := wal.AppendLogs(lItems, data)
+	wal.Mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	//readers are not blocked by the fsync
+	return wal.SyncAfterWrite()
+}
+
+//The index the next appended log must have in strict index mode,
+//0 if the wal is empty and not reset, then any index starts the logs.
+//The caller holds wal.writeMutex.
+func (wal *AlfheimDBWAL) NextAppendIndex() int64 {
+	if wal.FileIndex.Len() != 0 {
+		return wal.MaxIndex + 1
+	}
+	return wal.Meta.NextIndex
+}
+
+//The logs must be next, next+1, ..., ErrOutOfOrder if not. With next 0 the first log may be any index above 0.
+func CheckAppendIndex(lItems []*LogItem, next int64) error {
+	for _, lItem := range lItems {
+		if next == 0 && lItem.Index < 1 {
+			return fmt.Errorf("%w: log %d is appended, the index starts from 1", ErrOutOfOrder, lItem.Index)
+		}
+		if next != 0 && lItem.Index != next {
+			return fmt.Errorf("%w: log %d is appended, the next index is %d", ErrOutOfOrder, lItem.Index, next)
+		}
+		next = lItem.Index + 1
+	}
+	return nil
+}
+
+//Write logs which may replace the last logs, such as the logs of a new raft leader.
+//The first log must be in [FirstIndex, LastIndex+1], or ErrOutOfOrder, and the logs must be contiguous.
+//The logs from the first one are truncated by TruncateBack, or Reset if all logs are replaced,
+//then the new logs are appended, under one write lock, so no other write comes between them.
+//Overwrite does not merge with the group commit, it is synced unless the sync policy is SyncInterval or SyncNever.
+func (wal *AlfheimDBWAL) Overwrite(lItems []*LogItem, data []byte) error {
 	if len(lItems) == 0 || len(data) == 0 {
-		logrus.Warn("Empty logs written.")
-		return
+		wal.Logger.Warn("Empty logs written.")
+		return nil
+	}
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	first := lItems[0].Index
+	next := wal.NextAppendIndex()
+	min := next
+	if wal.FileIndex.Len() != 0 {
+		min = wal.MinIndex
+	}
+	if first < 1 || (next != 0 && (first < min || first > next)) {
+		return fmt.Errorf("%w: overwrite from %d, the logs are [%d, %d]", ErrOutOfOrder, first, min, next-1)
+	}
+	err := CheckAppendIndex(lItems, first)
+	if err != nil {
+		return err
+	}
+
+	wal.Mutex.Lock()
+	if wal.FileIndex.Len() != 0 && first <= wal.MaxIndex {
+		if first == wal.MinIndex {
+			err = wal.ResetFiles(first)
+		} else {
+			err = wal.TruncateBackFiles(first - 1)
+		}
+	}
+	if err == nil {
+		err = wal.AppendLogs(lItems, data)
 	}
-	firstIndex := lItems[0].Index
-	if wal.FileIndex.Len() == 0 || wal.FileIndex.Back().Value.(*AlfheimDBWALFile).LogIndex.Len() >= int(wal.MaxItems) {
-		aFile := wal.CreateNewFile(firstIndex)
-		aFile.BatchWriteLogs(lItems, data)
-		wal.FileIndex.Set(aFile.MinIndex, aFile)
-		wal.AFiles[aFile.MinIndex] = aFile
+	wal.Mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return wal.SyncAfterWrite()
+}
+
+//write logs to the last file, or to new files if the last one is full.
+//A batch bigger than the space left in the last file is split across files.
+//The caller holds wal.writeMutex and wal.Mutex, and syncs by the sync policy.
+func (wal *AlfheimDBWAL) AppendLogs(lItems []*LogItem, data []byte) error {
+	err := CheckAppendData(lItems, data)
+	if err != nil {
+		return err
+	}
+
+	//the parts already written, rollback them if a later part fails,
+	//so the batch is written or not as a whole
+	written := make([]*AppendedLogs, 0, 1)
+	//a batch of more than one log is framed by control items, so a crash never leaves a part of it
+	atomic := len(lItems) > 1
+	for len(lItems) != 0 {
+		aFile, created, count, length, err := wal.FileForAppend(lItems, atomic)
+		if err != nil {
+			wal.RollbackAppend(written)
+			return err
+		}
+		part := &AppendedLogs{AFile: aFile, Created: created, Pos: aFile.Pos, LItems: lItems[:count]}
+		if !atomic {
+			err = aFile.BatchWriteLogs(part.LItems, data[:length])
+		} else if count == len(lItems) {
+			err = aFile.BatchWriteLogsAtomic(part.LItems, data[:length], BATCH_COMMIT)
+		} else {
+			err = aFile.BatchWriteLogsAtomic(part.LItems, data[:length], BATCH_CONTINUE)
+		}
+		if err != nil {
+			if created {
+				wal.RemoveFile(aFile)
+			} else {
+				aFile.RollbackLogs(part.Pos, nil)
+			}
+			wal.RollbackAppend(written)
+			return err
+		}
+		if created {
+			wal.FileIndex.Set(aFile.MinIndex, aFile)
+			wal.AFiles[aFile.MinIndex] = aFile
+		}
 		wal.RefreshMinAndMaxIndex(aFile)
+		written = append(written, part)
+		lItems = lItems[count:]
+		data = data[length:]
+	}
+	return nil
+}
+
+//data must be the frames of lItems
+func CheckAppendData(lItems []*LogItem, data []byte) error {
+	var length int64
+	for _, lItem := range lItems {
+		length = length + LOG_ITEM_HEADER_LENGTH + int64(lItem.Length)
+	}
+	if int64(len(data)) != length {
+		return fmt.Errorf("append logs: data is %d bytes, log items need %d bytes", len(data), length)
+	}
+	return nil
+}
+
+//a part of a batch written to one file
+type AppendedLogs struct {
+	AFile *AlfheimDBWALFile
+	//the file is created for this batch
+	Created bool
+	//the file pos before the part is written
+	Pos    int64
+	LItems []*LogItem
+}
+
+//undo the written parts of a failed batch, remove the created files
+func (wal *AlfheimDBWAL) RollbackAppend(written []*AppendedLogs) {
+	if len(written) == 0 {
 		return
 	}
+	for i := len(written) - 1; i >= 0; i-- {
+		part := written[i]
+		if part.Created {
+			wal.FileIndex.Remove(part.AFile.MinIndex)
+			delete(wal.AFiles, part.AFile.MinIndex)
+			wal.RemoveFile(part.AFile)
+			continue
+		}
+		err := part.AFile.RollbackLogs(part.Pos, part.LItems)
+		if err != nil {
+			wal.Logger.Error("Rollback logs error, ", part.AFile.Filename, err)
+		}
+	}
+	wal.RefreshAllMinAndMaxIndex()
+}
 
-	elem := wal.FileIndex.Back()
-	aFile := elem.Value.(*AlfheimDBWALFile)
-	aFile.BatchWriteLogs(lItems, data)
-	wal.RefreshMinAndMaxIndex(aFile)
-	return
+//The file the lItems append to, and how many logs and bytes of lItems fit in it.
+//created is true if the file is new, it is not in FileIndex yet.
+func (wal *AlfheimDBWAL) FileForAppend(lItems []*LogItem, atomic bool) (aFile *AlfheimDBWALFile, created bool, count int, length int64, err error) {
+	if !wal.NeedCreateNewFile() {
+		aFile = wal.FileIndex.Back().Value.(*AlfheimDBWALFile)
+		count, length = wal.SegmentCapacity(aFile, lItems, atomic)
+		if count != 0 {
+			return aFile, false, count, length, nil
+		}
+	}
+	//the sealed file is synced before logs go to the next one by any sync policy,
+	//a torn tail is only in the last file, or the wal is corrupt when opened
+	if wal.FileIndex.Len() != 0 {
+		err = wal.FileIndex.Back().Value.(*AlfheimDBWALFile).Sync()
+		if err != nil {
+			return nil, false, 0, 0, err
+		}
+	}
+	aFile, err = wal.CreateNewFile(lItems[0].Index)
+	if err != nil {
+		return nil, false, 0, 0, err
+	}
+	count, length = wal.SegmentCapacity(aFile, lItems, atomic)
+	return aFile, true, count, length, nil
+}
+
+//How many logs of lItems fit in aFile by SegmentMaxItems and SegmentMaxBytes, and their bytes.
+//An empty file takes at least one log, even if it is bigger than SegmentMaxBytes.
+//An atomic part takes two control items more.
+func (wal *AlfheimDBWAL) SegmentCapacity(aFile *AlfheimDBWALFile, lItems []*LogItem, atomic bool) (int, int64) {
+	var overhead int64
+	if atomic {
+		overhead = 2 * CONTROL_ITEM_LENGTH
+	}
+	var length int64
+	for i, lItem := range lItems {
+		if int64(aFile.LogIndex.Len()+i) >= wal.Options.SegmentMaxItems {
+			return i, length
+		}
+		itemLength := LOG_ITEM_HEADER_LENGTH + int64(lItem.Length)
+		if wal.Options.SegmentMaxBytes > 0 && aFile.Pos+overhead+length+itemLength > wal.Options.SegmentMaxBytes {
+			if i != 0 || aFile.LogIndex.Len() != 0 {
+				return i, length
+			}
+		}
+		length = length + itemLength
+	}
+	return len(lItems), length
 }
 
 //Time complexity:
 //Find file in skipList , if i = len(files) => T(i) = O(logi)
 //Read log in file, T(j) = O(1)
 //T(i,j) = O(logi) + O(1) = O(logi)
-func (wal *AlfheimDBWAL) GetLog(index int64) []byte {
-	wal.Mutex.Lock()
-	defer wal.Mutex.Unlock()
+func (wal *AlfheimDBWAL) GetLog(index int64) ([]byte, error) {
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		return nil, ErrClosed
+	}
 	if wal.FileIndex.Len() == 0 {
-		return nil
+		return nil, ErrNotFound
 	}
 	if index < wal.MinIndex || index > wal.MaxIndex {
-		return nil
+		return nil, ErrNotFound
 	}
-	elem := wal.FileIndex.Find(index)
+	elem := wal.FindFileElem(index)
 	if elem == nil {
-		elem = wal.FileIndex.Back()
-	} else {
-		if index != elem.Key().(int64) {
-			elem = elem.Prev()
+		return nil, ErrNotFound
+	}
+	aFile := elem.Value.(*AlfheimDBWALFile)
+	return aFile.ReadLog(index)
+}
+
+//Read logs [start, end] in order, stop at the first missing index, so the logs are [start, start+len(logs)-1].
+//The logs are read file by file, one lock and one read per file.
+//Stop before the data bytes exceed maxBytes, but at least one log is returned, maxBytes <= 0 is no limit.
+func (wal *AlfheimDBWAL) GetLogs(start, end int64, maxBytes int64) ([][]byte, error) {
+	logs := make([][]byte, 0)
+	var size int64
+	next := start
+	for next <= end {
+		fileLogs, full, err := wal.GetFileLogs(next, end, maxBytes-size, len(logs) == 0)
+		if err != nil {
+			return nil, err
 		}
+		for _, log := range fileLogs {
+			size = size + int64(len(log))
+		}
+		logs = append(logs, fileLogs...)
+		next = next + int64(len(fileLogs))
+		if full || len(fileLogs) == 0 || (maxBytes > 0 && size >= maxBytes) {
+			break
+		}
+	}
+	if len(logs) == 0 {
+		return nil, ErrNotFound
+	}
+	return logs, nil
+}
 
+//Read logs [start, end] of the file which has start, full is true if the byte budget is used up,
+//or the logs stop before the end of the file. first means no log is read yet, take one log whatever the budget.
+func (wal *AlfheimDBWAL) GetFileLogs(start, end int64, maxBytes int64, first bool) (logs [][]byte, full bool, err error) {
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		return nil, false, ErrClosed
+	}
+	if wal.FileIndex.Len() == 0 || start < wal.MinIndex || start > wal.MaxIndex {
+		return nil, false, nil
+	}
+	elem := wal.FindFileElem(start)
+	if elem == nil {
+		return nil, false, nil
 	}
 	aFile := elem.Value.(*AlfheimDBWALFile)
-	return aFile.ReadLog(index)
+	lItems := make([]*LogItem, 0)
+	var size int64
+	next := start
+	for lElem := aFile.LogIndex.Get(start); lElem != nil; lElem = lElem.Next() {
+		lItem := lElem.Value.(*LogItem)
+		if lItem.Index > end {
+			break
+		}
+		if lItem.Index != next {
+			full = true
+			break
+		}
+		if (!first || len(lItems) != 0) && maxBytes > 0 && size+int64(lItem.Length) > maxBytes {
+			full = true
+			break
+		}
+		size = size + int64(lItem.Length)
+		lItems = append(lItems, lItem)
+		next++
+	}
+	logs, err = aFile.ReadLogs(lItems)
+	return logs, full, err
+}
+
+//The file elem which may have the index, nil if the index is before the first file.
+//The caller holds wal.Mutex.
+func (wal *AlfheimDBWAL) FindFileElem(index int64) *skiplist.Element {
+	elem := wal.FileIndex.Find(index)
+	if elem == nil {
+		return wal.FileIndex.Back()
+	}
+	if index != elem.Key().(int64) {
+		return elem.Prev()
+	}
+	return elem
+}
+
+//no file, the last file is full or too old, or the last file is written by an old log item version
+func (wal *AlfheimDBWAL) NeedCreateNewFile() bool {
+	if wal.FileIndex.Len() == 0 {
+		return true
+	}
+	aFile := wal.FileIndex.Back().Value.(*AlfheimDBWALFile)
+	if aFile.Version() != LOG_ITEM_VERSION {
+		return true
+	}
+	if int64(aFile.LogIndex.Len()) >= wal.Options.SegmentMaxItems {
+		return true
+	}
+	if wal.Options.SegmentMaxBytes > 0 && aFile.Pos >= wal.Options.SegmentMaxBytes {
+		return true
+	}
+	if wal.Options.SegmentMaxAge > 0 && time.Since(aFile.CreateTime) >= wal.Options.SegmentMaxAge {
+		return true
+	}
+	return false
 }
 
 //log file name: log_${unixtimestamp}_index.dat
-func (wal *AlfheimDBWAL) CreateNewFile(index int64) *AlfheimDBWALFile {
+func (wal *AlfheimDBWAL) CreateNewFile(index int64) (*AlfheimDBWALFile, error) {
 	fileName := fmt.Sprintf("log_%d_%d.dat", time.Now().Unix(), index)
 	fullName := filepath.Join(wal.Dirname, fileName)
-	return NewAlfheimDBWALFile(fullName)
+	return NewAlfheimDBWALFile(fullName, &wal.Options)
+}
+
+//close and remove a file which is not in the file index
+func (wal *AlfheimDBWAL) RemoveFile(aFile *AlfheimDBWALFile) error {
+	aFile.MarkTruncated()
+	aFile.Close()
+	err := os.Remove(aFile.Filename)
+	if err != nil {
+		wal.Logger.Error("Remove file error, ", aFile.Filename, err)
+		return NewIOError("remove", aFile.Filename, err)
+	}
+	return nil
 }
 
 //refresh min and max index
@@ -196,19 +792,52 @@ func (wal *AlfheimDBWAL) RefreshAllMinAndMaxIndex() {
 	for _, v := range wal.AFiles {
 		wal.RefreshMinAndMaxIndex(v)
 	}
-	logrus.Info("The min log is:", wal.MinIndex, ", max log is:", wal.MaxIndex)
+	wal.Logger.Info("The min log is:", wal.MinIndex, ", max log is:", wal.MaxIndex)
 }
 
 //truncate log, [start, end]
-func (wal *AlfheimDBWAL) TruncateLog(start, end int64) {
+//In strict index mode the range must touch the first or the last log, or ErrOutOfOrder.
+func (wal *AlfheimDBWAL) TruncateLog(start, end int64) error {
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
 	wal.Mutex.Lock()
 	defer wal.Mutex.Unlock()
+	//a hole in the middle breaks the contiguous logs of strict index mode
+	if wal.Options.StrictIndex && wal.FileIndex.Len() != 0 && start > wal.MinIndex && end < wal.MaxIndex {
+		return fmt.Errorf("%w: truncate log [%d, %d] leaves a gap in the logs [%d, %d], truncate the front or the back", ErrOutOfOrder, start, end, wal.MinIndex, wal.MaxIndex)
+	}
+	//subscriptions stop before the truncated tail
+	if end >= wal.MaxIndex {
+		wal.RetractDurable(start - 1)
+	}
+	//all logs are truncated, the next index of the last Reset is cleared, the wal is empty like a new one,
+	//so it never goes back to an older reset point, and any index starts the logs, such as after a snapshot
+	if wal.FileIndex.Len() != 0 && start <= wal.MinIndex && end >= wal.MaxIndex && wal.Meta.NextIndex != 0 {
+		meta := *wal.Meta
+		meta.NextIndex = 0
+		saved := wal.Meta
+		wal.Meta = &meta
+		err := wal.SaveMeta()
+		if err != nil {
+			wal.Meta = saved
+			return err
+		}
+	}
 
-	RangeAlfheimDBWALFile(wal.FileIndex, start, end,
-		func(key int64, aFile *AlfheimDBWALFile) bool {
-			logrus.Info("Truncate file: ", aFile.Filename)
+	err := RangeAlfheimDBWALFile(wal.FileIndex, start, end,
+		func(key int64, aFile *AlfheimDBWALFile) (bool, error) {
+			wal.Logger.Info("Truncate file: ", aFile.Filename)
 			//truncate logx
-			flag := aFile.TruncateLog(start, end)
+			flag, err := aFile.TruncateLog(start, end)
+			if err != nil {
+				return true, err
+			}
 			switch flag {
 			case NO_TRUNCATED:
 				fallthrough
@@ -217,32 +846,41 @@ func (wal *AlfheimDBWAL) TruncateLog(start, end int64) {
 			case REMOVE_FILE:
 				// need remove
 				if aFile.LogIndex.Len() == 0 || flag == REMOVE_FILE {
-					aFile.Close()
-					err := os.Remove(aFile.File.Name())
+					//the file before may end with a batch part continued in this file
+					prev := wal.FileIndex.Get(key).Prev()
+					if prev != nil {
+						err := prev.Value.(*AlfheimDBWALFile).SealContinuedBatch()
+						if err != nil {
+							return true, err
+						}
+					}
+					err := wal.RemoveFile(aFile)
 					if err != nil {
-						logrus.Fatal("Remove file error, ", err)
+						return true, err
 					}
-					logrus.Info("File remove: ", aFile.Filename)
-					delete(wal.AFiles, aFile.MinIndex)
-					return false
+					wal.Logger.Info("File remove: ", aFile.Filename)
+					delete(wal.AFiles, key)
+					return false, nil
 				}
 			default:
-				logrus.Fatal("Unknow truncate stat: ", flag)
+				return true, fmt.Errorf("unknow truncate stat: %d", flag)
 			}
-			return true
+			return true, nil
 		})
 
 	//refresh min and max index from all file
 	wal.RefreshAllMinAndMaxIndex()
+	return err
 }
 
-func RangeAlfheimDBWALFile(sList *skiplist.SkipList, startIndex, endIndex int64, exec func(key int64, value *AlfheimDBWALFile) bool) {
+//exec returns false if the file need remove from sList, range stops at the first error
+func RangeAlfheimDBWALFile(sList *skiplist.SkipList, startIndex, endIndex int64, exec func(key int64, value *AlfheimDBWALFile) (bool, error)) error {
 	if sList.Len() == 0 {
-		return
+		return nil
 	}
 
 	if sList.Front().Key().(int64) > endIndex || sList.Back().Value.(*AlfheimDBWALFile).MaxIndex < startIndex {
-		return
+		return nil
 	}
 
 	var firstAFileElem *skiplist.Element
@@ -265,14 +903,18 @@ func RangeAlfheimDBWALFile(sList *skiplist.SkipList, startIndex, endIndex int64,
 	}
 
 	for {
-		b := exec(firstAFileElem.Key().(int64), firstAFileElem.Value.(*AlfheimDBWALFile))
+		b, err := exec(firstAFileElem.Key().(int64), firstAFileElem.Value.(*AlfheimDBWALFile))
 		next := firstAFileElem.Next()
 		if !b {
 			sList.Remove(firstAFileElem.Key())
 		}
+		if err != nil {
+			return err
+		}
 		if next == nil || next.Key().(int64) > endIndex {
 			break
 		}
 		firstAFileElem = next
 	}
+	return nil
 }
@@ -0,0 +1,101 @@
+package alfheimdbwal
+
+import (
+	"testing"
+)
+
+func TestDropUncommittedBatch(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	batchTestLogs(t, wal, 4, 8)
+	closeTestWAL(t, wal)
+
+	//the commit control item is torn, the logs of the batch are whole
+	chopTestFile(t, testLogFiles(t, dir)[0], 3)
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 3)
+	if len(wal.RecoveryReport.TruncatedFiles) != 1 {
+		t.Fatalf("recovery report has %d files, want 1", len(wal.RecoveryReport.TruncatedFiles))
+	}
+	batchTestLogs(t, wal, 4, 6)
+	checkTestLogs(t, wal, 1, 6)
+}
+
+func TestDropSplitBatchWithoutLastPart(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 2)
+	//logs 3-5 are continued in the first file, 6-8 are committed in the second
+	batchTestLogs(t, wal, 3, 8)
+	closeTestWAL(t, wal)
+
+	filenames := testLogFiles(t, dir)
+	if len(filenames) != 2 {
+		t.Fatalf("%d files, want 2", len(filenames))
+	}
+	chopTestFile(t, filenames[1], 3)
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 2)
+	if n := len(testLogFiles(t, dir)); n != 1 {
+		t.Fatalf("%d files after recovery, want 1", n)
+	}
+	batchTestLogs(t, wal, 3, 8)
+	checkTestLogs(t, wal, 1, 8)
+}
+
+func TestSplitBatchSyncsSealedPart(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	opts.SyncPolicy = SyncNever
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	batchTestLogs(t, wal, 1, 12)
+
+	//a torn part in a sealed file can not be repaired, every part but the last is synced
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.FileIndex.Len() != 3 {
+		t.Fatalf("%d files, want 3", wal.FileIndex.Len())
+	}
+	for elem := wal.FileIndex.Front(); elem.Next() != nil; elem = elem.Next() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.Dirty {
+			t.Fatalf("sealed file %s is not synced", aFile.Filename)
+		}
+	}
+}
+
+func TestSplitBatchByItems(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	batchTestLogs(t, wal, 4, 20)
+
+	//the batch fills the first file, then goes to new files
+	wal.Mutex.RLock()
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.LogIndex.Len() != 5 {
+			t.Errorf("file %s has %d logs, want 5", aFile.Filename, aFile.LogIndex.Len())
+		}
+	}
+	files := wal.FileIndex.Len()
+	wal.Mutex.RUnlock()
+	if files != 4 {
+		t.Fatalf("%d files, want 4", files)
+	}
+	checkTestLogs(t, wal, 1, 20)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 20)
+}
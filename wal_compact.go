@@ -0,0 +1,116 @@
+package alfheimdbwal
+
+import (
+	"path/filepath"
+	"time"
+)
+
+//Rewrite every file which has truncated logs, so the truncated bytes are freed.
+//The files are compacted one by one, see CompactFiles.
+func (wal *AlfheimDBWAL) Compact() error {
+	return wal.CompactFiles(0)
+}
+
+//Compact the files whose truncated bytes are more than ratio of their log bytes.
+//Writers wait for the file being compacted, readers only wait for the swap of the file.
+func (wal *AlfheimDBWAL) CompactFiles(ratio float64) error {
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	keys, err := wal.CompactCandidates(ratio)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = wal.CompactFile(key, ratio)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//min index of the files to compact
+func (wal *AlfheimDBWAL) CompactCandidates(ratio float64) ([]int64, error) {
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return nil, ErrClosed
+	}
+	keys := make([]int64, 0)
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		if NeedCompact(elem.Value.(*AlfheimDBWALFile), ratio) {
+			keys = append(keys, elem.Key().(int64))
+		}
+	}
+	return keys, nil
+}
+
+//the file has truncated bytes, and more than ratio of its log bytes
+func NeedCompact(aFile *AlfheimDBWALFile, ratio float64) bool {
+	truncated := aFile.TruncatedBytes()
+	if truncated == 0 {
+		return false
+	}
+	return float64(truncated) >= ratio*float64(aFile.Pos-aFile.HeaderLength)
+}
+
+//Compact the file of key if it still needs.
+//The live logs are copied to a new file while readers read the old one,
+//then the new file is renamed over it, wal.Mutex is held only to swap the file in.
+func (wal *AlfheimDBWAL) CompactFile(key int64, ratio float64) error {
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	//the file may be truncated or removed since the candidates are found
+	elem := wal.FileIndex.Get(key)
+	if elem == nil {
+		return nil
+	}
+	aFile := elem.Value.(*AlfheimDBWALFile)
+	if !NeedCompact(aFile, ratio) {
+		return nil
+	}
+	wal.Logger.Infof("Compact file %s, %d of %d bytes are truncated", aFile.Filename, aFile.TruncatedBytes(), aFile.Pos-aFile.HeaderLength)
+	start := time.Now()
+	newFile, err := aFile.WriteRewriteFile(nil)
+	if err != nil {
+		return err
+	}
+	err = aFile.RenameRewriteFile(newFile)
+	if err != nil {
+		return err
+	}
+	wal.Mutex.Lock()
+	aFile.Replace(newFile)
+	wal.Mutex.Unlock()
+	wal.Logger.Infof("File %s is compacted to %d bytes in %s", aFile.Filename, aFile.Pos, time.Since(start))
+	return SyncDir(filepath.Dir(aFile.Filename))
+}
+
+//background compaction every Options.CompactInterval
+func (wal *AlfheimDBWAL) StartCompactLoop() {
+	if wal.Options.CompactInterval == 0 || wal.Options.CompactRatio == 0 {
+		return
+	}
+	wal.compactStop = make(chan struct{})
+	wal.wg.Add(1)
+	go func() {
+		defer wal.wg.Done()
+		ticker := time.NewTicker(wal.Options.CompactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := wal.CompactFiles(wal.Options.CompactRatio)
+				if err != nil {
+					wal.Logger.Error("Background compact error, ", err)
+				}
+			case <-wal.compactStop:
+				return
+			}
+		}
+	}()
+}
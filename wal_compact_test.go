@@ -0,0 +1,56 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncAllDirtyFilesAfterCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	opts.SyncPolicy = SyncNever
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 13)
+	err := wal.TruncateLog(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wal.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the file before the clean rewritten file is dirty
+	wal.writeMutex.Lock()
+	wal.FileIndex.Front().Value.(*AlfheimDBWALFile).Dirty = true
+	wal.writeMutex.Unlock()
+	err = wal.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.Mutex.RLock()
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.Dirty {
+			t.Errorf("file %s is not synced", aFile.Filename)
+		}
+	}
+	wal.Mutex.RUnlock()
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	for i := int64(1); i <= 13; i++ {
+		data, err := wal.GetLog(i)
+		if i == 8 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("get truncated log 8 returns %v, want %v", err, ErrNotFound)
+			}
+			continue
+		}
+		if err != nil || string(data) != string(testData(i)) {
+			t.Fatalf("log %d is %q, %v, want %q", i, data, err, testData(i))
+		}
+	}
+}
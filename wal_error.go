@@ -0,0 +1,83 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	//The data on disk is broken, can not be trusted
+	ErrCorrupt = errors.New("alfheimdbwal: corrupt")
+	//The log index is not in the wal
+	ErrNotFound = errors.New("alfheimdbwal: not found")
+	//The wal or the wal file is closed
+	ErrClosed = errors.New("alfheimdbwal: closed")
+	//The os or syscall returned an error, see IOError
+	ErrIO = errors.New("alfheimdbwal: io error")
+	//The options are not valid
+	ErrInvalidOptions = errors.New("alfheimdbwal: invalid options")
+	//The options are different from the ones the wal is created with
+	ErrIncompatibleOptions = errors.New("alfheimdbwal: incompatible options")
+	//Write or truncate a wal opened read only
+	ErrReadOnly = errors.New("alfheimdbwal: read only")
+	//The file is written by a newer version, or in a format this version can not read
+	ErrUnsupportedVersion = errors.New("alfheimdbwal: unsupported version")
+	//The file header does not fit in the header length
+	ErrHeaderOverflow = errors.New("alfheimdbwal: file header overflow")
+	//The wal dir is locked by another wal, in this process or another one
+	ErrLocked = errors.New("alfheimdbwal: locked")
+	//The index is out of the logs in the wal
+	ErrOutOfRange = errors.New("alfheimdbwal: out of range")
+	//The appended log does not continue the last log, in strict mode
+	ErrOutOfOrder = errors.New("alfheimdbwal: out of order")
+)
+
+//IOError wraps the error returned by the os or syscall layer.
+//errors.Is(err, ErrIO) is true, and errors.Is(err, syscall.EIO) works on the wrapped error.
+type IOError struct {
+	Op       string
+	Filename string
+	Err      error
+}
+
+func NewIOError(op, filename string, err error) *IOError {
+	return &IOError{Op: op, Filename: filename, Err: err}
+}
+
+func (e *IOError) Error() string {
+	//os.PathError has the op and the filename already
+	var pathErr *os.PathError
+	if errors.As(e.Err, &pathErr) {
+		return fmt.Sprintf("%s: %v", ErrIO, e.Err)
+	}
+	return fmt.Sprintf("%s: %s %s: %v", ErrIO, e.Op, e.Filename, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IOError) Is(target error) bool {
+	return target == ErrIO
+}
+
+//CorruptError reports where the broken data is.
+//errors.Is(err, ErrCorrupt) is true.
+type CorruptError struct {
+	Filename string
+	Offset   int64
+	Reason   string
+}
+
+func NewCorruptError(filename string, offset int64, reason string) *CorruptError {
+	return &CorruptError{Filename: filename, Offset: offset, Reason: reason}
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d: %s", ErrCorrupt, e.Filename, e.Offset, e.Reason)
+}
+
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
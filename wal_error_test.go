@@ -0,0 +1,71 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestNotFoundError(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	_, err := wal.GetLog(1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get log of an empty wal returns %v, want %v", err, ErrNotFound)
+	}
+	writeTestLogs(t, wal, 1, 3)
+	for _, index := range []int64{0, 4} {
+		_, err = wal.GetLog(index)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get log %d returns %v, want %v", index, err, ErrNotFound)
+		}
+	}
+}
+
+func TestCorruptError(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	closeTestWAL(t, wal)
+
+	//break the checksum of the preamble
+	filename := testLogFiles(t, dir)[0]
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0xff}, FILE_PREAMBLE_LENGTH-1)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWALWithOptions(dir, opts)
+	var corruptErr *CorruptError
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &corruptErr) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrCorrupt)
+	}
+	if corruptErr.Filename != filename {
+		t.Fatalf("corrupt file is %s, want %s", corruptErr.Filename, filename)
+	}
+}
+
+func TestIOError(t *testing.T) {
+	//the wal dir is a file
+	filename := filepath.Join(t.TempDir(), "wal")
+	err := ioutil.WriteFile(filename, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWALWithOptions(filename, testOptions())
+	var ioErr *IOError
+	if !errors.Is(err, ErrIO) || !errors.As(err, &ioErr) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrIO)
+	}
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("io error %v does not wrap %v", err, syscall.ENOTDIR)
+	}
+}
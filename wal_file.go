@@ -9,11 +9,17 @@
 package alfheimdbwal
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/huandu/skiplist"
 	"github.com/sirupsen/logrus"
@@ -23,14 +29,16 @@ import (
 // ┌───────────┬───────────┐
 // │ 1K header │   logs    │
 // └───────────┴───────────┘
-// The file header struct:
-// ┌───────────────┬─────────────────────────────┐
-// │ Length 8Bytes │             Data            │
-// └───────────────┴─────────────────────────────┘
-// The log item struct:
-// ┌───────────────┬──────────────┬─────────────────────────────────┐
-// │ Length 8Bytes │ Index 8Bytes │              Data               │
-// └───────────────┴──────────────┴─────────────────────────────────┘
+// The file header struct, see AlfheimDBWALFilePreamble and EncodeHeaderSlot:
+// ┌──────────────────┬──────────────┬──────────────┐
+// │ Preamble 44Bytes │ Header Slot0 │ Header Slot1 │
+// └──────────────────┴──────────────┴──────────────┘
+// The log item struct (version 2):
+// ┌───────────────┬──────────────┬───────────────┬──────────────────┐
+// │ Length 8Bytes │ Index 8Bytes │ CRC32C 4Bytes │       Data       │
+// └───────────────┴──────────────┴───────────────┴──────────────────┘
+// Files written before version 2 have no CRC32C, they are read only
+// and never appended.
 type AlfheimDBWALFile struct {
 	Mutex        *sync.Mutex
 	File         *os.File
@@ -40,13 +48,38 @@ type AlfheimDBWALFile struct {
 	MaxIndex     int64
 	MinIndex     int64
 	Filename     string
+	//nil if the file is written by an old version without preamble
+	Preamble     *AlfheimDBWALFilePreamble
+	//seq of the header slot holding Header, format version 2
+	HeaderSeq    uint64
 	Header       *AlfheimDBWALFileHeader
 	HeaderLength int64
-	AppendFlag   bool
+	//written but not synced
+	Dirty bool
+	//bytes after Pos which are not a whole log item, such as a torn write
+	TornBytes int64
+	//pos of the last batch part which ends with BATCH_CONTINUE, -1 is none.
+	//The batch is committed only if the next file has the rest.
+	ContinuedPos int64
+	//times the file is replaced by a rewrite, the positions of the logs change
+	RewriteCount int64
+	//times the logs of the file are truncated, see MarkTruncated, it is atomic
+	TruncateCount int64
+	//from the preamble, or parsed from the file name, see CreateNewFile
+	CreateTime time.Time
+	//parsed from the file name, the index the file is created for
+	FirstIndex int64
+	Options    *Options
+	Logger     logrus.FieldLogger
 }
 
 type AlfheimDBWALFileHeader struct {
+	//log item version, 0 is version 1
+	Version      int             `json:"version,omitempty"`
 	TruncateArea []*TruncateArea `json:"truncate_area"`
+	//all logs before CommitPos are committed, even if their batch has no end control item,
+	//it is set when a batch is cut by truncate
+	CommitPos int64 `json:"commit_pos,omitempty"`
 }
 
 //[start, end)
@@ -55,52 +88,236 @@ type TruncateArea struct {
 	End   int64 `json:"end"`
 }
 
-func NewAlfheimDBWALFile(filename string) *AlfheimDBWALFile {
+func NewAlfheimDBWALFile(filename string, opts *Options) (*AlfheimDBWALFile, error) {
+	aFile := newAlfheimDBWALFile(filename, opts)
+	err := aFile.BuildLogIndex()
+	if err != nil {
+		return nil, err
+	}
+	return aFile, nil
+}
+
+//the file is not opened yet
+func newAlfheimDBWALFile(filename string, opts *Options) *AlfheimDBWALFile {
 	aFile := new(AlfheimDBWALFile)
 	aFile.MinIndex = -1
 	aFile.Filename = filename
-	// 1K header
-	aFile.HeaderLength = 1 << 10
-
+	aFile.Options = opts
+	aFile.Logger = opts.Logger
+	aFile.HeaderLength = opts.HeaderLength
+	aFile.CreateTime, aFile.FirstIndex = ParseFileName(filename)
 	aFile.Mutex = new(sync.Mutex)
-	aFile.BuildLogIndex()
 	return aFile
 }
 
-func (aFile *AlfheimDBWALFile) LoadFileHeader() {
-	header := new(AlfheimDBWALFileHeader)
-	lengthBytes := make([]byte, 8)
-	aFile.AppendFlag = false
-	n := ReadFile(*aFile.File, 0, 8, lengthBytes)
-	if n != 8 {
-		logrus.Info("No have file header, init file header")
-		header.TruncateArea = []*TruncateArea{}
-		aFile.Header = header
-		aFile.SaveFileHeader()
-	} else {
-		length := ReadInt64FromBuff(lengthBytes, true)
-		buff := make([]byte, length)
-		aFile.AppendFlag = false
-		ReadFile(*aFile.File, 8, int64(length), buff)
-		err := json.Unmarshal(buff, header)
+//log file name: log_${unixtimestamp}_index.dat, now and 0 if the name does not match
+func ParseFileName(filename string) (time.Time, int64) {
+	var unix, index int64
+	_, err := fmt.Sscanf(filepath.Base(filename), "log_%d_%d.dat", &unix, &index)
+	if err != nil {
+		return time.Now(), 0
+	}
+	return time.Unix(unix, 0), index
+}
+
+//Load the file header, init it if the file is new.
+//A file is created by writing its header and fsync, the header may be torn if it crashed before the fsync,
+//the file has no log then, the header is written again.
+func (aFile *AlfheimDBWALFile) LoadFileHeader() error {
+	err := aFile.ReadFileHeader()
+	if err == nil || !errors.Is(err, ErrCorrupt) {
+		return err
+	}
+	torn, tornErr := aFile.IsTornHeader()
+	if tornErr != nil {
+		return tornErr
+	}
+	if !torn {
+		return err
+	}
+	aFile.Preamble = nil
+	aFile.Header = &AlfheimDBWALFileHeader{Version: LOG_ITEM_VERSION, TruncateArea: []*TruncateArea{}}
+	//a read only wal never writes, a file without header has no log
+	if aFile.Options.ReadOnly {
+		aFile.Logger.Info("No have file header, ", aFile.Filename)
+		return nil
+	}
+	aFile.Logger.Info("No have file header, init file header")
+	return aFile.InitFileHeader()
+}
+
+func (aFile *AlfheimDBWALFile) ReadFileHeader() error {
+	buff := make([]byte, FILE_PREAMBLE_LENGTH)
+	n, err := ReadFile(*aFile.File, 0, int64(len(buff)), buff)
+	if err != nil {
+		return err
+	}
+	buff = buff[:n]
+	if n == 0 {
+		return NewCorruptError(aFile.Filename, 0, "no file header")
+	}
+	if bytes.HasPrefix(buff, FILE_MAGIC) {
+		preamble, err := DecodeFilePreamble(aFile.Filename, buff)
 		if err != nil {
-			logrus.Fatal("Load file header error, ", err)
+			return err
 		}
-		aFile.Header = header
+		aFile.Preamble = preamble
+		aFile.HeaderLength = preamble.HeaderLength
+		aFile.CreateTime = preamble.CreateTime
+		if preamble.FormatVersion >= FILE_FORMAT_VERSION_2 {
+			return aFile.ReadHeaderSlots()
+		}
+		return aFile.ReadFileHeaderBody()
+	}
+	//the old header starts with the big endian length, less than header length
+	if buff[0] != 0 {
+		return NewCorruptError(aFile.Filename, 0, "no magic, not a wal file")
+	}
+	return aFile.ReadFileHeaderBody()
+}
+
+//pos of the length prefixed JSON of the file header, format version 0 and 1
+func (aFile *AlfheimDBWALFile) HeaderBodyPos() int64 {
+	if aFile.Preamble == nil {
+		return 0
+	}
+	return FILE_PREAMBLE_LENGTH
+}
+
+func (aFile *AlfheimDBWALFile) ReadFileHeaderBody() error {
+	bodyPos := aFile.HeaderBodyPos()
+	lengthBytes := make([]byte, 8)
+	n, err := ReadFile(*aFile.File, bodyPos, 8, lengthBytes)
+	if err != nil {
+		return err
+	}
+	if n != 8 {
+		return NewCorruptError(aFile.Filename, bodyPos, "file header is short")
+	}
+	length := ReadInt64FromBuff(lengthBytes, true)
+	if length > uint64(aFile.HeaderLength-bodyPos-8) {
+		return NewCorruptError(aFile.Filename, bodyPos, fmt.Sprintf("file header is %d bytes, greater than header length %d", length, aFile.HeaderLength))
+	}
+	buff := make([]byte, length)
+	n, err = ReadFile(*aFile.File, bodyPos+8, int64(length), buff)
+	if err != nil {
+		return err
 	}
+	if n != int64(length) {
+		return NewCorruptError(aFile.Filename, bodyPos, "file header is short")
+	}
+	header, err := DecodeFileHeader(aFile.Filename, bodyPos, buff)
+	if err != nil {
+		return err
+	}
+	aFile.Header = header
+	return nil
+}
 
+//Decode the JSON of the file header at pos of filename
+func DecodeFileHeader(filename string, pos int64, buff []byte) (*AlfheimDBWALFileHeader, error) {
+	header := new(AlfheimDBWALFileHeader)
+	err := json.Unmarshal(buff, header)
+	if err != nil {
+		return nil, NewCorruptError(filename, pos, fmt.Sprintf("load file header: %v", err))
+	}
+	if header.Version == 0 {
+		header.Version = LOG_ITEM_VERSION_1
+	}
+	if header.Version > LOG_ITEM_VERSION {
+		return nil, fmt.Errorf("%w: %s is log item version %d, this version reads up to %d", ErrUnsupportedVersion, filename, header.Version, LOG_ITEM_VERSION)
+	}
+	return header, nil
 }
 
-func (aFile *AlfheimDBWALFile) SaveFileHeader() {
+//true if the header is torn when the file is created: the file has no log, and the header is empty, zeros or a broken preamble
+func (aFile *AlfheimDBWALFile) IsTornHeader() (bool, error) {
+	fileInfo, err := aFile.File.Stat()
+	if err != nil {
+		return false, NewIOError("stat", aFile.Filename, err)
+	}
+	if fileInfo.Size() > aFile.Options.HeaderLength {
+		return false, nil
+	}
+	buff := make([]byte, FILE_PREAMBLE_LENGTH)
+	n, err := ReadFile(*aFile.File, 0, int64(len(buff)), buff)
+	if err != nil {
+		return false, err
+	}
+	return IsTornPreamble(buff[:n]), nil
+}
+
+//Write the preamble and the header of a new file
+func (aFile *AlfheimDBWALFile) InitFileHeader() error {
+	aFile.HeaderLength = aFile.Options.HeaderLength
+	aFile.Preamble = &AlfheimDBWALFilePreamble{
+		FormatVersion: FILE_FORMAT_VERSION,
+		IsBigEndian:   true,
+		HeaderLength:  aFile.HeaderLength,
+		CreateTime:    aFile.CreateTime,
+		FirstIndex:    aFile.FirstIndex,
+	}
+	body, err := aFile.MarshalFileHeader()
+	if err != nil {
+		return err
+	}
+	aFile.HeaderSeq = 1
+	slot := EncodeHeaderSlot(aFile.HeaderSeq, body)
+	slotPos := aFile.HeaderSlotPos(aFile.HeaderSeq)
+	buff := make([]byte, slotPos+int64(len(slot)))
+	copy(buff, aFile.Preamble.Encode())
+	copy(buff[slotPos:], slot)
+	return aFile.WriteHeader(0, buff)
+}
+
+//log item version of this file
+func (aFile *AlfheimDBWALFile) Version() int {
+	return aFile.Header.Version
+}
+
+//log item header length of this file
+func (aFile *AlfheimDBWALFile) ItemHeaderLength() int64 {
+	return LogItemHeaderLength(aFile.Header.Version)
+}
+
+//the JSON of the file header
+func (aFile *AlfheimDBWALFile) MarshalFileHeader() ([]byte, error) {
 	b, err := json.Marshal(aFile.Header)
 	if err != nil {
-		logrus.Fatal("Save file header error, ", err)
+		return nil, fmt.Errorf("save file header of %s: %w", aFile.Filename, err)
+	}
+	return b, nil
+}
+
+//Save the header to a header slot, the preamble is never changed.
+//ErrHeaderOverflow if the header does not fit in the header slot, nothing is written.
+//A file of format version 0 and 1 has no header slot, it is rewritten by SaveHeaderOrRewrite instead.
+func (aFile *AlfheimDBWALFile) SaveFileHeader() error {
+	if aFile.FormatVersion() < FILE_FORMAT_VERSION_2 {
+		return fmt.Errorf("save file header of %s: format version %d has no header slot", aFile.Filename, aFile.FormatVersion())
+	}
+	b, err := aFile.MarshalFileHeader()
+	if err != nil {
+		return err
 	}
-	buff := make([]byte, len(b)+8)
-	WriteInt64ToBuff(buff, int64(len(b)), true)
-	copy(buff[8:], b)
-	aFile.AppendFlag = false
-	WriteFile(*aFile.File, 0, buff, aFile.AppendFlag)
+	return aFile.SaveHeaderSlot(b)
+}
+
+//format version of the file, 0 if it has no preamble
+func (aFile *AlfheimDBWALFile) FormatVersion() uint32 {
+	if aFile.Preamble == nil {
+		return FILE_FORMAT_VERSION_0
+	}
+	return aFile.Preamble.FormatVersion
+}
+
+//Write buff of the header at pos, the header is always synced, whatever the sync policy
+func (aFile *AlfheimDBWALFile) WriteHeader(pos int64, buff []byte) error {
+	err := WriteFile(*aFile.File, pos, buff)
+	if err != nil {
+		return err
+	}
+	return SyncFile(*aFile.File)
 }
 
 //true: ths pos is Truncated
@@ -113,22 +330,90 @@ func (aFile *AlfheimDBWALFile) FilterTruncated(pos int64) bool {
 	return false
 }
 
-func (aFile *AlfheimDBWALFile) ReadLog(index int64) []byte {
+func (aFile *AlfheimDBWALFile) ReadLog(index int64) ([]byte, error) {
+	if aFile.File == nil {
+		return nil, ErrClosed
+	}
 	if index > aFile.MaxIndex {
-		return nil
+		return nil, ErrNotFound
 	}
 	if index < aFile.MinIndex {
-		return nil
+		return nil, ErrNotFound
 	}
 
-	if lItem, ok := aFile.LogItems[index]; ok {
-		buff := make([]byte, lItem.Length)
-		aFile.AppendFlag = false
-		n := ReadFile(*aFile.File, int64(lItem.Pos), int64(lItem.Length), buff)
-		if n == 0 {
-			return nil
+	lItem, ok := aFile.LogItems[index]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	//read the whole log item, check it before return
+	headerLength := aFile.ItemHeaderLength()
+	framePos := int64(lItem.Pos) - headerLength
+	buff := make([]byte, headerLength+int64(lItem.Length))
+	n, err := ReadFile(*aFile.File, framePos, int64(len(buff)), buff)
+	if err != nil {
+		return nil, err
+	}
+	if n != int64(len(buff)) {
+		return nil, NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("log %d is short, read %d of %d bytes", index, n, len(buff)))
+	}
+	err = aFile.CheckLogItem(aFile.Version(), framePos, buff, lItem)
+	if err != nil {
+		return nil, err
+	}
+	return buff[headerLength:], nil
+}
+
+//Read lItems in one read from the first log to the last log, lItems are in order of pos
+func (aFile *AlfheimDBWALFile) ReadLogs(lItems []*LogItem) ([][]byte, error) {
+	if aFile.File == nil {
+		return nil, ErrClosed
+	}
+	if len(lItems) == 0 {
+		return nil, nil
+	}
+	headerLength := aFile.ItemHeaderLength()
+	first := lItems[0]
+	last := lItems[len(lItems)-1]
+	startPos := int64(first.Pos) - headerLength
+	endPos := int64(last.Pos) + int64(last.Length)
+	buff := make([]byte, endPos-startPos)
+	n, err := ReadFile(*aFile.File, startPos, int64(len(buff)), buff)
+	if err != nil {
+		return nil, err
+	}
+	if n != int64(len(buff)) {
+		return nil, NewCorruptError(aFile.Filename, startPos, fmt.Sprintf("logs [%d, %d] are short, read %d of %d bytes", first.Index, last.Index, n, len(buff)))
+	}
+	logs := make([][]byte, len(lItems))
+	for i, lItem := range lItems {
+		framePos := int64(lItem.Pos) - headerLength
+		frame := buff[framePos-startPos : int64(lItem.Pos)+int64(lItem.Length)-startPos]
+		err = aFile.CheckLogItem(aFile.Version(), framePos, frame, lItem)
+		if err != nil {
+			return nil, err
 		}
-		return buff
+		logs[i] = frame[headerLength:]
+	}
+	return logs, nil
+}
+
+//check the log item of version read from framePos is the lItem, and the checksum is right.
+//The version is passed by the caller, a reader without wal.Mutex can not read aFile.Header.
+func (aFile *AlfheimDBWALFile) CheckLogItem(version int, framePos int64, buff []byte, lItem *LogItem) error {
+	length := ReadInt64FromBuff(buff, true)
+	if version >= LOG_ITEM_VERSION_3 {
+		length = length &^ LOG_ITEM_CONTROL_FLAG
+	}
+	index := int64(ReadInt64FromBuff(buff[8:], true))
+	if length != lItem.Length || index != lItem.Index {
+		return NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("log item header mismatch, want index %d length %d, got index %d length %d", lItem.Index, lItem.Length, index, length))
+	}
+	if version == LOG_ITEM_VERSION_1 {
+		return nil
+	}
+	checksum := ReadUint32FromBuff(buff[16:], true)
+	if LogItemChecksum(buff[:16], buff[LOG_ITEM_HEADER_LENGTH_V2:]) != checksum {
+		return NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("log %d checksum mismatch", index))
 	}
 	return nil
 }
@@ -141,16 +426,17 @@ const (
 	TRUNCATED_OK TruncateStatus = 0
 )
 
-func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) TruncateStatus {
+func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) (TruncateStatus, error) {
 	if aFile.LogIndex.Len() == 0 {
-		return REMOVE_FILE
+		return REMOVE_FILE, nil
 	}
 	if aFile.MaxIndex < start {
-		return NO_TRUNCATED
+		return NO_TRUNCATED, nil
 	}
 	if aFile.MinIndex > end {
-		return NO_TRUNCATED
+		return NO_TRUNCATED, nil
 	}
+	aFile.MarkTruncated()
 
 	// The log min index is 5, max index is 13
 	// If start in (-,5] && end in [13,-)
@@ -159,7 +445,7 @@ func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) TruncateStatus {
 	// │5│6│7│8│9│10│11│12│13│
 	// └─┴─┴─┴─┴─┴──┴──┴──┴──┘
 	if aFile.MaxIndex <= end && aFile.MinIndex >= start {
-		return REMOVE_FILE
+		return REMOVE_FILE, nil
 	}
 	// The log min index is 5, max index is 13
 	// If start in [5,13) && end in [13,-)
@@ -168,20 +454,34 @@ func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) TruncateStatus {
 	// │5│6│7│8│9│10│11│12│13│
 	// └─┴─┴─┴─┴─┴──┴──┴──┴──┘
 	if aFile.MaxIndex <= end && aFile.MinIndex <= start {
-		logrus.Infof("case 1: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
+		aFile.Logger.Infof("case 1: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
 		lItem := aFile.LogIndex.Find(start)
 		if lItem == nil {
-			return NO_TRUNCATED
+			return NO_TRUNCATED, nil
+		}
+		truncateLogPos := lItem.Value.(*LogItem).Pos - uint64(aFile.ItemHeaderLength())
+		//the batch of the start log may lose its end control item, keep the logs before start committed
+		if aFile.Version() >= LOG_ITEM_VERSION_3 {
+			rewritten, err := aFile.SaveCommitPos(int64(truncateLogPos), func(lItem *LogItem) bool {
+				return lItem.Index < start
+			})
+			if err != nil {
+				return NO_TRUNCATED, err
+			}
+			if rewritten {
+				return TRUNCATED_OK, nil
+			}
 		}
-		truncateLogPos := lItem.Value.(*LogItem).Pos - 8 - 8
 		err := aFile.File.Truncate(int64(truncateLogPos))
 		if err != nil {
-			log.Fatal("TruncateLog error, ", truncateLogPos, err)
+			return NO_TRUNCATED, NewIOError("truncate", aFile.Filename, err)
 		}
-		logrus.Infof("case 1: Truncate file %d, %d, %d, %d", lItem.Value.(*LogItem).Index, truncateLogPos, aFile.MinIndex, aFile.MaxIndex)
-		aFile.Close()
-		aFile.BuildLogIndex()
-		return TRUNCATED_OK
+		err = SyncFile(*aFile.File)
+		if err != nil {
+			return NO_TRUNCATED, err
+		}
+		aFile.Logger.Infof("case 1: Truncate file %d, %d, %d, %d", lItem.Value.(*LogItem).Index, truncateLogPos, aFile.MinIndex, aFile.MaxIndex)
+		return TRUNCATED_OK, aFile.Reload()
 	}
 
 	// The log min index is 5, max index is 13
@@ -192,18 +492,18 @@ func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) TruncateStatus {
 	// │5│6│7│8│9│10│11│12│13│
 	// └─┴─┴─┴─┴─┴──┴──┴──┴──┘
 	if aFile.MaxIndex >= end && start <= aFile.MinIndex {
-		logrus.Infof("case2: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
+		aFile.Logger.Infof("case2: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
 		elem := aFile.LogIndex.Find(end)
 		if elem == nil {
-			logrus.Fatal("TruncateLog error, ", start, end, aFile.MaxIndex, aFile.MinIndex)
+			return NO_TRUNCATED, fmt.Errorf("%w: truncate log [%d, %d] of %s, log %d not found", ErrNotFound, start, end, aFile.Filename, end)
 		}
 		lItem := elem.Value.(*LogItem)
 		ta := TruncateArea{Start: 0 + aFile.HeaderLength, End: int64(lItem.Pos) + int64(lItem.Length)}
-		aFile.Header.TruncateArea = append(aFile.Header.TruncateArea, &ta)
-		aFile.SaveFileHeader()
-		aFile.Close()
-		aFile.BuildLogIndex()
-		return TRUNCATED_OK
+		err := aFile.TruncateArea(&ta, start, end)
+		if err != nil {
+			return NO_TRUNCATED, err
+		}
+		return TRUNCATED_OK, nil
 	}
 
 	// The log min index is 5, max index is 13
@@ -214,177 +514,565 @@ func (aFile *AlfheimDBWALFile) TruncateLog(start, end int64) TruncateStatus {
 	// └─┴─┴─┴─┴─┴──┴──┴──┴──┘
 	// Put these pos into TruncateArea
 	if aFile.MaxIndex >= end && start >= aFile.MinIndex {
-		logrus.Infof("case3: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
+		aFile.Logger.Infof("case3: Truncate file %d, %d, %d, %d", start, end, aFile.MinIndex, aFile.MaxIndex)
 		startElem := aFile.LogIndex.Find(start)
 		if startElem == nil {
-			logrus.Fatal("TruncateLog error, ", start, end, aFile.MaxIndex, aFile.MinIndex)
+			return NO_TRUNCATED, fmt.Errorf("%w: truncate log [%d, %d] of %s, log %d not found", ErrNotFound, start, end, aFile.Filename, start)
 		}
 		startlItem := startElem.Value.(*LogItem)
 
 		endElem := aFile.LogIndex.Find(end)
 		if endElem == nil {
-			logrus.Fatal("TruncateLog error, ", start, end, aFile.MaxIndex, aFile.MinIndex)
+			return NO_TRUNCATED, fmt.Errorf("%w: truncate log [%d, %d] of %s, log %d not found", ErrNotFound, start, end, aFile.Filename, end)
 		}
 		endlItem := endElem.Value.(*LogItem)
 
-		ta := TruncateArea{Start: int64(startlItem.Pos) - 16, End: int64(endlItem.Pos) + int64(endlItem.Length)}
-		aFile.Header.TruncateArea = append(aFile.Header.TruncateArea, &ta)
-		aFile.SaveFileHeader()
-		aFile.Close()
-		aFile.BuildLogIndex()
-		return TRUNCATED_OK
+		ta := TruncateArea{Start: int64(startlItem.Pos) - aFile.ItemHeaderLength(), End: int64(endlItem.Pos) + int64(endlItem.Length)}
+		err := aFile.TruncateArea(&ta, start, end)
+		if err != nil {
+			return NO_TRUNCATED, err
+		}
+		return TRUNCATED_OK, nil
 	}
-	logrus.Fatal("Unknow truncateLog error, ", start, end, aFile.MaxIndex, aFile.MinIndex)
-	return TRUNCATED_OK
+	return NO_TRUNCATED, fmt.Errorf("unknow truncate log [%d, %d] of %s, min index %d, max index %d", start, end, aFile.Filename, aFile.MinIndex, aFile.MaxIndex)
 }
 
-func WriteFile(file os.File, pos int64, data []byte, appendFlag bool) {
-	if !appendFlag {
-		_, err := file.Seek(pos, 0)
-		if err != nil {
-			logrus.Fatal("Seek file error", err)
-		}
+//Mark the logs of the file truncated, before the file or its index is changed.
+//An iterator reads the file without wal.Mutex, it checks the count after a read, see Iterator.
+func (aFile *AlfheimDBWALFile) MarkTruncated() {
+	atomic.AddInt64(&aFile.TruncateCount, 1)
+}
+
+//close and rebuild the log index from disk
+func (aFile *AlfheimDBWALFile) Reload() error {
+	err := aFile.Close()
+	if err != nil {
+		return err
 	}
+	return aFile.BuildLogIndex()
+}
 
-	length := 0
-	for {
-		l, err := file.Write(data)
-		if err != nil {
-			log.Fatal("Write file err, ", err)
-		}
-		length = l + length
-		if length == len(data) {
-			break
-		}
-		data = data[length:]
+//Write data at pos, the file offset is not used, so reads at any pos do not race with it
+func WriteFile(file os.File, pos int64, data []byte) error {
+	_, err := file.WriteAt(data, pos)
+	if err != nil {
+		return NewIOError("write", file.Name(), err)
 	}
+	return nil
+}
 
+func SyncFile(file os.File) error {
 	err := syscall.Fsync(int(file.Fd()))
+	if err != nil {
+		return NewIOError("fsync", file.Name(), err)
+	}
+	return nil
+}
 
+//fsync the dir, so the created, renamed and removed files in it are durable
+func SyncDir(dirname string) error {
+	dir, err := os.Open(dirname)
 	if err != nil {
-		logrus.Fatal("Sync disk error, ", err)
+		return NewIOError("open", dirname, err)
 	}
+	defer dir.Close()
+	return SyncFile(*dir)
 }
 
-func ReadFile(file os.File, pos, length int64, buff []byte) int64 {
-	_, err := file.Seek(pos, 0)
+//write data to a temp file, fsync it, then rename it to filename.
+//The filename has the old data or the new data, never a part of them.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	err := ReplaceFile(filename, data, perm)
 	if err != nil {
-		logrus.Fatal("Seek file error, ", err)
+		return err
 	}
-	var readCount int64
-	for readCount != length {
+	return SyncDir(filepath.Dir(filename))
+}
 
-		n, err := file.Read(buff[readCount:])
-		if err != nil {
-			if err.Error() == "EOF" {
-				if n > 0 {
-					return readCount + int64(n)
-				}
-				return readCount
-			}
-			logrus.Fatal("Read file error, ", err)
-		}
-		if n == 0 || n == -1 {
-			logrus.Fatal("File have dirty data")
-		}
-		readCount = readCount + int64(n)
+//WriteFileAtomic without the fsync of the dir, the rename is durable after SyncDir
+func ReplaceFile(filename string, data []byte, perm os.FileMode) error {
+	tmpname := filename + ".tmp"
+	file, err := os.OpenFile(tmpname, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return NewIOError("open", tmpname, err)
+	}
+	err = WriteFile(*file, 0, data)
+	if err == nil {
+		err = SyncFile(*file)
+	}
+	closeErr := file.Close()
+	if err == nil && closeErr != nil {
+		err = NewIOError("close", tmpname, closeErr)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	err = os.Rename(tmpname, filename)
+	if err != nil {
+		os.Remove(tmpname)
+		return NewIOError("rename", tmpname, err)
+	}
+	return nil
+}
+
+//Read length bytes at pos to buff, return less than length at the end of file.
+//ReadAt does not use the file offset, many goroutines can read the file at the same time.
+func ReadFile(file os.File, pos, length int64, buff []byte) (int64, error) {
+	n, err := file.ReadAt(buff[:length], pos)
+	if err == io.EOF {
+		return int64(n), nil
 	}
-	return readCount
+	if err != nil {
+		return int64(n), NewIOError("read", file.Name(), err)
+	}
+	return int64(n), nil
 }
 
-func (aFile *AlfheimDBWALFile) WriteLog(lItem *LogItem, data []byte) {
-	WriteFile(*aFile.File, aFile.Pos, data, aFile.AppendFlag)
-	aFile.AppendFlag = true
-	lItem.Pos = uint64(aFile.Pos) + 8 + 8
+func (aFile *AlfheimDBWALFile) WriteLog(lItem *LogItem, data []byte) error {
+	if aFile.File == nil {
+		return ErrClosed
+	}
+	if int64(len(data)) != aFile.ItemHeaderLength()+int64(lItem.Length) {
+		return fmt.Errorf("write log %d to %s: data is %d bytes, log item need %d bytes", lItem.Index, aFile.Filename, len(data), aFile.ItemHeaderLength()+int64(lItem.Length))
+	}
+	err := WriteFile(*aFile.File, aFile.Pos, data)
+	if err != nil {
+		return err
+	}
+	aFile.Dirty = true
+	lItem.Pos = uint64(aFile.Pos + aFile.ItemHeaderLength())
 	aFile.Pos = int64(lItem.Pos) + int64(lItem.Length)
 	aFile.LogIndex.Set(lItem.Index, lItem)
 	aFile.LogItems[lItem.Index] = lItem
 	aFile.RefreshMinAndMaxIndex(lItem)
+	return nil
 }
 
-func (aFile *AlfheimDBWALFile) BatchWriteLogs(lItems []*LogItem, data []byte) {
-	WriteFile(*aFile.File, aFile.Pos, data, aFile.AppendFlag)
-	aFile.AppendFlag = true
+func (aFile *AlfheimDBWALFile) BatchWriteLogs(lItems []*LogItem, data []byte) error {
+	if aFile.File == nil {
+		return ErrClosed
+	}
+	var length int64
 	for _, lItem := range lItems {
-		lItem.Pos = uint64(aFile.Pos) + 8 + 8
+		length = length + aFile.ItemHeaderLength() + int64(lItem.Length)
+	}
+	if int64(len(data)) != length {
+		return fmt.Errorf("batch write logs to %s: data is %d bytes, log items need %d bytes", aFile.Filename, len(data), length)
+	}
+	err := WriteFile(*aFile.File, aFile.Pos, data)
+	if err != nil {
+		return err
+	}
+	aFile.Dirty = true
+	for _, lItem := range lItems {
+		lItem.Pos = uint64(aFile.Pos + aFile.ItemHeaderLength())
 		aFile.Pos = int64(lItem.Pos) + int64(lItem.Length)
 		aFile.LogIndex.Set(lItem.Index, lItem)
 		aFile.LogItems[lItem.Index] = lItem
 		aFile.RefreshMinAndMaxIndex(lItem)
 	}
+	return nil
+}
+
+//Write a batch part framed by BATCH_BEGIN and end, end is BATCH_COMMIT or BATCH_CONTINUE.
+//The logs are dropped when the file is loaded if the end control item is not on disk.
+func (aFile *AlfheimDBWALFile) BatchWriteLogsAtomic(lItems []*LogItem, data []byte, end ControlType) error {
+	if aFile.File == nil {
+		return ErrClosed
+	}
+	if aFile.Version() < LOG_ITEM_VERSION_3 {
+		return fmt.Errorf("batch write logs to %s: log item version %d has no control item", aFile.Filename, aFile.Version())
+	}
+	var length int64
+	for _, lItem := range lItems {
+		length = length + aFile.ItemHeaderLength() + int64(lItem.Length)
+	}
+	if int64(len(data)) != length {
+		return fmt.Errorf("batch write logs to %s: data is %d bytes, log items need %d bytes", aFile.Filename, len(data), length)
+	}
+	buff := make([]byte, CONTROL_ITEM_LENGTH+len(data)+CONTROL_ITEM_LENGTH)
+	NewControlItemBuff(BATCH_BEGIN, lItems[0].Index, len(lItems), buff, true)
+	copy(buff[CONTROL_ITEM_LENGTH:], data)
+	NewControlItemBuff(end, lItems[0].Index, len(lItems), buff[CONTROL_ITEM_LENGTH+len(data):], true)
+	err := WriteFile(*aFile.File, aFile.Pos, buff)
+	if err != nil {
+		return err
+	}
+	aFile.Dirty = true
+	if end == BATCH_CONTINUE {
+		aFile.ContinuedPos = aFile.Pos
+	}
+	aFile.Pos = aFile.Pos + CONTROL_ITEM_LENGTH
+	for _, lItem := range lItems {
+		lItem.Pos = uint64(aFile.Pos + aFile.ItemHeaderLength())
+		aFile.Pos = int64(lItem.Pos) + int64(lItem.Length)
+		aFile.LogIndex.Set(lItem.Index, lItem)
+		aFile.LogItems[lItem.Index] = lItem
+		aFile.RefreshMinAndMaxIndex(lItem)
+	}
+	aFile.Pos = aFile.Pos + CONTROL_ITEM_LENGTH
+	return nil
+}
+
+//The next file which has the rest of the continued batch is removed by truncate,
+//mark the continued batch part committed.
+func (aFile *AlfheimDBWALFile) SealContinuedBatch() error {
+	if aFile.ContinuedPos == -1 {
+		return nil
+	}
+	_, err := aFile.SaveCommitPos(aFile.Pos, nil)
+	if err != nil {
+		return err
+	}
+	aFile.ContinuedPos = -1
+	return nil
+}
+
+//The next file which has the rest of the continued batch is lost in a crash,
+//drop the continued batch part, the batch is not committed.
+func (aFile *AlfheimDBWALFile) DropContinuedBatch() error {
+	if aFile.ContinuedPos == -1 {
+		return nil
+	}
+	aFile.Logger.Warnf("Batch at %d of %s is not committed, truncate it", aFile.ContinuedPos, aFile.Filename)
+	err := aFile.File.Truncate(aFile.ContinuedPos)
+	if err != nil {
+		return NewIOError("truncate", aFile.Filename, err)
+	}
+	err = SyncFile(*aFile.File)
+	if err != nil {
+		return err
+	}
+	return aFile.Reload()
 }
 
-func (aFile *AlfheimDBWALFile) BuildLogIndex() {
+//Undo a failed or rolled back write, truncate the file to pos and remove lItems from the index
+func (aFile *AlfheimDBWALFile) RollbackLogs(pos int64, lItems []*LogItem) error {
+	if aFile.File == nil {
+		return ErrClosed
+	}
+	aFile.MarkTruncated()
+	err := aFile.File.Truncate(pos)
+	if err != nil {
+		return NewIOError("truncate", aFile.Filename, err)
+	}
+	//the rolled back logs must not come back after a crash
+	err = SyncFile(*aFile.File)
+	if err != nil {
+		return err
+	}
+	aFile.Pos = pos
+	if aFile.ContinuedPos >= pos {
+		aFile.ContinuedPos = -1
+	}
+	for _, lItem := range lItems {
+		aFile.LogIndex.Remove(lItem.Index)
+		delete(aFile.LogItems, lItem.Index)
+	}
+	aFile.MaxIndex = 0
+	aFile.MinIndex = -1
+	if aFile.LogIndex.Len() != 0 {
+		aFile.RefreshMinAndMaxIndex(aFile.LogIndex.Front().Value.(*LogItem))
+		aFile.RefreshMinAndMaxIndex(aFile.LogIndex.Back().Value.(*LogItem))
+	}
+	return nil
+}
+
+func (aFile *AlfheimDBWALFile) BuildLogIndex() error {
 	var err error
-	//open file with os.O_RDWR and os.O_CREATE, 644
-	aFile.File, err = os.OpenFile(aFile.Filename, os.O_RDWR|os.O_CREATE, 0644)
+	//open file with os.O_RDWR and os.O_CREATE, or os.O_RDONLY if the wal is read only
+	flag := os.O_RDWR | os.O_CREATE
+	if aFile.Options.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	aFile.File, err = os.OpenFile(aFile.Filename, flag, aFile.Options.FileMode)
 	if err != nil {
-		logrus.Fatal("Open file error, ", err)
+		return NewIOError("open", aFile.Filename, err)
 	}
-	logrus.Info("Init wal file, ", aFile.Filename)
+	aFile.Logger.Info("Init wal file, ", aFile.Filename)
 
 	aFile.MaxIndex = 0
 	aFile.MinIndex = -1
+	aFile.TornBytes = 0
+	aFile.ContinuedPos = -1
 
 	//load file header
-	aFile.LoadFileHeader()
-	var pos, allLength int64
-	pos = aFile.HeaderLength
+	err = aFile.LoadFileHeader()
+	if err != nil {
+		aFile.Close()
+		return err
+	}
+	fileInfo, err := aFile.File.Stat()
+	if err != nil {
+		aFile.Close()
+		return NewIOError("stat", aFile.Filename, err)
+	}
+	fileSize := fileInfo.Size()
+	headerLength := aFile.ItemHeaderLength()
+	pos := aFile.HeaderLength
 
 	//tree index
 	sList := skiplist.New(skiplist.Int64)
 	//map index
 	logItems := make(map[int64]*LogItem)
 
-	buff := make([]byte, 16)
+	buff := make([]byte, headerLength)
 	indexCount := 0
+	setIndex := func(lItem *LogItem) {
+		indexCount++
+		logItems[lItem.Index] = lItem
+		sList.Set(lItem.Index, lItem)
+		aFile.RefreshMinAndMaxIndex(lItem)
+	}
+
+	//the logs of the batch part not ended yet, batchPos is -1 if not in a batch
+	var pending []*LogItem
+	batchPos := int64(-1)
+	commitBatch := func() {
+		for _, lItem := range pending {
+			setIndex(lItem)
+		}
+		pending = pending[:0]
+		batchPos = -1
+	}
 
 	for {
 
 		//Read length
-		aFile.AppendFlag = false
-		count := ReadFile(*aFile.File, pos, int64(len(buff)), buff)
+		count, err := ReadFile(*aFile.File, pos, int64(len(buff)), buff)
+		if err != nil {
+			aFile.Close()
+			return err
+		}
 		if int(count) != len(buff) {
 			if count > 0 {
-				logrus.Fatal("Read dirty bytes, ", count)
+				aFile.Logger.Warnf("Read %d dirty bytes at %d of %s", count, pos, aFile.Filename)
+				aFile.TornBytes = fileSize - pos
 			}
-			logrus.Info("Read over")
+			aFile.Logger.Info("Read over")
 			break
 		}
 		lItem := new(LogItem)
 		lItem.Length = ReadInt64FromBuff(buff, true)
+		control := false
+		if aFile.Version() >= LOG_ITEM_VERSION_3 && lItem.Length&LOG_ITEM_CONTROL_FLAG != 0 {
+			control = true
+			lItem.Length = lItem.Length &^ LOG_ITEM_CONTROL_FLAG
+		}
 
 		//Read index
 		lItem.Index = int64(ReadInt64FromBuff(buff[8:], true))
-		allLength = allLength + int64(lItem.Length) + 8 + 8
-		lItem.Pos = uint64(pos) + 8 + 8
+		lItem.Pos = uint64(pos + headerLength)
+		if lItem.Length > uint64(fileSize-int64(lItem.Pos)) {
+			//the last log item is torn, or the length is broken, then the whole log items after it are durable
+			//and the file is corrupt. A file without checksum can not tell them.
+			if aFile.Version() != LOG_ITEM_VERSION_1 {
+				found, err := aFile.HasLogItemAfter(int64(lItem.Pos), fileSize)
+				if err != nil {
+					aFile.Close()
+					return err
+				}
+				if found {
+					aFile.Close()
+					return NewCorruptError(aFile.Filename, pos, fmt.Sprintf("log %d length %d is out of file size %d, whole log items are after it", lItem.Index, lItem.Length, fileSize))
+				}
+			}
+			aFile.Logger.Warnf("Log %d length %d at %d is out of file size %d, %s", lItem.Index, lItem.Length, pos, fileSize, aFile.Filename)
+			aFile.TornBytes = fileSize - pos
+			break
+		}
 
-		pos = allLength + aFile.HeaderLength
+		framePos := pos
+		pos = int64(lItem.Pos) + int64(lItem.Length)
 
 		//filter if log is truncated
 		if aFile.FilterTruncated(int64(lItem.Pos)) {
-			logrus.Info("Log is Truncated: ", *lItem)
+			aFile.Logger.Info("Log is Truncated: ", *lItem)
+			continue
+		}
+
+		//check crc32c
+		var frame []byte
+		if aFile.Version() != LOG_ITEM_VERSION_1 {
+			frame = make([]byte, headerLength+int64(lItem.Length))
+			count, err = ReadFile(*aFile.File, framePos, int64(len(frame)), frame)
+			if err != nil {
+				aFile.Close()
+				return err
+			}
+			if count != int64(len(frame)) {
+				aFile.Close()
+				return NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("log %d is short, read %d of %d bytes", lItem.Index, count, len(frame)))
+			}
+			err = aFile.CheckLogItem(aFile.Version(), framePos, frame, lItem)
+			if err != nil {
+				//the last log item is broken, or all bytes after the broken one are zero,
+				//it is a torn write, else the file is corrupt
+				zeroTail, zeroErr := aFile.IsZeroTail(pos, fileSize)
+				if zeroErr != nil {
+					aFile.Close()
+					return zeroErr
+				}
+				if pos != fileSize && !zeroTail {
+					aFile.Close()
+					return err
+				}
+				aFile.Logger.Warn("Torn write, ", err)
+				pos = framePos
+				aFile.TornBytes = fileSize - pos
+				break
+			}
+		}
+
+		if control {
+			ctype, _ := ParseControlItem(frame[headerLength:], true)
+			switch ctype {
+			case BATCH_BEGIN:
+				if batchPos != -1 {
+					if batchPos >= aFile.Header.CommitPos {
+						aFile.Close()
+						return NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("batch at %d is not ended", batchPos))
+					}
+					commitBatch()
+				}
+				batchPos = framePos
+			case BATCH_COMMIT, BATCH_CONTINUE:
+				//the begin control item may be truncated, the logs after it are set already
+				aFile.ContinuedPos = -1
+				if ctype == BATCH_CONTINUE && batchPos != -1 {
+					aFile.ContinuedPos = batchPos
+				}
+				commitBatch()
+			default:
+				aFile.Close()
+				return NewCorruptError(aFile.Filename, framePos, fmt.Sprintf("unknow control item type %d", ctype))
+			}
 			continue
 		}
 
 		//if log is not truncated, set index
-		indexCount++
-		logItems[lItem.Index] = lItem
-		sList.Set(lItem.Index, lItem)
-		aFile.RefreshMinAndMaxIndex(lItem)
+		if batchPos != -1 {
+			pending = append(pending, lItem)
+			continue
+		}
+		setIndex(lItem)
 	}
-	logrus.Info("file load log item count : ", aFile.Filename, indexCount)
-	aFile.Pos = aFile.HeaderLength + allLength
+
+	//a batch without end control item is not committed, unless it is cut by truncate
+	if batchPos != -1 {
+		if batchPos < aFile.Header.CommitPos {
+			commitBatch()
+		} else {
+			aFile.Logger.Warnf("Batch at %d of %s is not committed, drop %d logs", batchPos, aFile.Filename, len(pending))
+			pos = batchPos
+			aFile.TornBytes = fileSize - pos
+		}
+	}
+	if aFile.ContinuedPos != -1 && aFile.ContinuedPos < aFile.Header.CommitPos {
+		aFile.ContinuedPos = -1
+	}
+	aFile.Logger.Info("file load log item count : ", aFile.Filename, indexCount)
+	aFile.Pos = pos
 	aFile.LogItems = logItems
 	aFile.LogIndex = sList
-	return
+	return nil
 }
 
-func (aFile *AlfheimDBWALFile) Close() {
+//true: all bytes in [pos, fileSize) are zero
+func (aFile *AlfheimDBWALFile) IsZeroTail(pos, fileSize int64) (bool, error) {
+	buff := make([]byte, 64<<10)
+	for pos < fileSize {
+		length := int64(len(buff))
+		if fileSize-pos < length {
+			length = fileSize - pos
+		}
+		n, err := ReadFile(*aFile.File, pos, length, buff)
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			break
+		}
+		for _, b := range buff[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		pos = pos + n
+	}
+	return true, nil
+}
+
+//true: a whole log item with the right checksum starts in [pos, fileSize), the bytes are not a torn write.
+//Every pos is tried, the length of the log item before may be broken.
+func (aFile *AlfheimDBWALFile) HasLogItemAfter(pos, fileSize int64) (bool, error) {
+	buff := make([]byte, fileSize-pos)
+	n, err := ReadFile(*aFile.File, pos, int64(len(buff)), buff)
+	if err != nil {
+		return false, err
+	}
+	buff = buff[:n]
+	for i := 0; i+LOG_ITEM_HEADER_LENGTH <= len(buff); i++ {
+		length := ReadInt64FromBuff(buff[i:], true)
+		if aFile.Version() >= LOG_ITEM_VERSION_3 {
+			length = length &^ LOG_ITEM_CONTROL_FLAG
+		}
+		if length > uint64(len(buff)-i-LOG_ITEM_HEADER_LENGTH) {
+			continue
+		}
+		frame := buff[i : i+LOG_ITEM_HEADER_LENGTH+int(length)]
+		if LogItemChecksum(frame[:16], frame[LOG_ITEM_HEADER_LENGTH:]) == ReadUint32FromBuff(frame[16:], true) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//truncate the torn bytes after the last whole log item
+func (aFile *AlfheimDBWALFile) RepairTail() error {
+	if aFile.TornBytes == 0 {
+		return nil
+	}
+	aFile.Logger.Warnf("Truncate %s to %d, drop %d torn bytes", aFile.Filename, aFile.Pos, aFile.TornBytes)
+	err := aFile.File.Truncate(aFile.Pos)
+	if err != nil {
+		return NewIOError("truncate", aFile.Filename, err)
+	}
+	err = SyncFile(*aFile.File)
+	if err != nil {
+		return err
+	}
+	aFile.TornBytes = 0
+	return nil
+}
+
+//fsync if the file is written after last sync
+func (aFile *AlfheimDBWALFile) Sync() error {
+	if aFile.File == nil {
+		return ErrClosed
+	}
+	if !aFile.Dirty {
+		return nil
+	}
+	err := SyncFile(*aFile.File)
+	if err != nil {
+		return err
+	}
+	aFile.Dirty = false
+	return nil
+}
+
+func (aFile *AlfheimDBWALFile) Close() error {
+	if aFile.File == nil {
+		return nil
+	}
 	err := aFile.File.Close()
+	aFile.File = nil
 	if err != nil {
-		logrus.Fatal("File close error, ", err)
+		return NewIOError("close", aFile.Filename, err)
 	}
+	return nil
 }
 
 func (aFile *AlfheimDBWALFile) RefreshMinAndMaxIndex(lItem *LogItem) {
@@ -0,0 +1,202 @@
+package alfheimdbwal
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// The file preamble, at offset 0 of the file header, it is written once when the file is created:
+// ┌────────────────┬────────────────────────┬─────────────────┬─────────────────┐
+// │ Magic 8Bytes   │ Format Version 4Bytes  │ Endian 1Byte    │ Reserved 3Bytes │
+// ├────────────────┴───────┬────────────────┴─────────┬───────┴─────────────────┤
+// │ Header Length 8Bytes   │ Create Time 8Bytes       │ First Index 8Bytes      │
+// ├────────────────────────┴──────────────────────────┴─────────────────────────┤
+// │ CRC32C 4Bytes                                                               │
+// └─────────────────────────────────────────────────────────────────────────────┘
+// The numbers after Endian are written in the byte order of Endian, the CRC32C covers the bytes before it.
+// Files without the magic are written by older versions, their header is the length prefixed JSON only.
+const (
+	//no preamble, the file header is the length prefixed JSON at offset 0
+	FILE_FORMAT_VERSION_0 = 0
+	//preamble, then the length prefixed JSON
+	FILE_FORMAT_VERSION_1 = 1
+	//preamble, then two header slots
+	FILE_FORMAT_VERSION_2 = 2
+	//new files are written with this version
+	FILE_FORMAT_VERSION = FILE_FORMAT_VERSION_2
+
+	FILE_PREAMBLE_LENGTH = 8 + 4 + 1 + 3 + 8 + 8 + 8 + 4
+	//Seq 8Bytes + Length 4Bytes + CRC32C 4Bytes
+	HEADER_SLOT_PREFIX_LENGTH = 8 + 4 + 4
+
+	FILE_BIG_ENDIAN    = 1
+	FILE_LITTLE_ENDIAN = 2
+)
+
+//the first bytes of a wal file, the first byte is never 0, so it is not the length of an old JSON header
+var FILE_MAGIC = []byte("AFDBWAL\n")
+
+type AlfheimDBWALFilePreamble struct {
+	FormatVersion uint32
+	IsBigEndian   bool
+	//the file header length, the first log is at HeaderLength
+	HeaderLength int64
+	CreateTime   time.Time
+	//the index the file is created for
+	FirstIndex int64
+}
+
+func (p *AlfheimDBWALFilePreamble) Encode() []byte {
+	buff := make([]byte, FILE_PREAMBLE_LENGTH)
+	copy(buff, FILE_MAGIC)
+	buff[12] = FILE_LITTLE_ENDIAN
+	if p.IsBigEndian {
+		buff[12] = FILE_BIG_ENDIAN
+	}
+	WriteUint32ToBuff(buff[8:], p.FormatVersion, p.IsBigEndian)
+	WriteInt64ToBuff(buff[16:], p.HeaderLength, p.IsBigEndian)
+	WriteInt64ToBuff(buff[24:], p.CreateTime.UnixNano(), p.IsBigEndian)
+	WriteInt64ToBuff(buff[32:], p.FirstIndex, p.IsBigEndian)
+	WriteUint32ToBuff(buff[40:], crc32.Checksum(buff[:40], Crc32cTable), p.IsBigEndian)
+	return buff
+}
+
+//Decode the preamble of filename, buff starts with FILE_MAGIC
+func DecodeFilePreamble(filename string, buff []byte) (*AlfheimDBWALFilePreamble, error) {
+	if len(buff) < FILE_PREAMBLE_LENGTH {
+		return nil, NewCorruptError(filename, 0, fmt.Sprintf("file preamble is short, %d of %d bytes", len(buff), FILE_PREAMBLE_LENGTH))
+	}
+	p := new(AlfheimDBWALFilePreamble)
+	switch buff[12] {
+	case FILE_BIG_ENDIAN:
+		p.IsBigEndian = true
+	case FILE_LITTLE_ENDIAN:
+		p.IsBigEndian = false
+	default:
+		return nil, NewCorruptError(filename, 12, fmt.Sprintf("unknow endian %d", buff[12]))
+	}
+	checksum := ReadUint32FromBuff(buff[40:], p.IsBigEndian)
+	if crc32.Checksum(buff[:40], Crc32cTable) != checksum {
+		return nil, NewCorruptError(filename, 0, "file preamble checksum mismatch")
+	}
+	p.FormatVersion = ReadUint32FromBuff(buff[8:], p.IsBigEndian)
+	if p.FormatVersion > FILE_FORMAT_VERSION {
+		return nil, fmt.Errorf("%w: %s is format version %d, this version reads up to %d", ErrUnsupportedVersion, filename, p.FormatVersion, FILE_FORMAT_VERSION)
+	}
+	if !p.IsBigEndian {
+		return nil, fmt.Errorf("%w: %s is little endian", ErrUnsupportedVersion, filename)
+	}
+	p.HeaderLength = int64(ReadInt64FromBuff(buff[16:], p.IsBigEndian))
+	if p.HeaderLength <= FILE_PREAMBLE_LENGTH {
+		return nil, NewCorruptError(filename, 16, fmt.Sprintf("header length %d is not greater than the preamble", p.HeaderLength))
+	}
+	p.CreateTime = time.Unix(0, int64(ReadInt64FromBuff(buff[24:], p.IsBigEndian)))
+	p.FirstIndex = int64(ReadInt64FromBuff(buff[32:], p.IsBigEndian))
+	return p, nil
+}
+
+//buff may be left by a torn preamble write: empty, zeros, or a broken preamble
+func IsTornPreamble(buff []byte) bool {
+	if len(buff) < len(FILE_MAGIC) && bytes.HasPrefix(FILE_MAGIC, buff) {
+		return true
+	}
+	return bytes.HasPrefix(buff, FILE_MAGIC) || len(bytes.Trim(buff, "\x00")) == 0
+}
+
+// Format version 2 has two header slots after the preamble, each takes half of the space left:
+// ┌─────────────┬───────────────┬───────────────┬──────┐
+// │ Seq 8Bytes  │ Length 4Bytes │ CRC32C 4Bytes │ JSON │
+// └─────────────┴───────────────┴───────────────┴──────┘
+// The CRC32C covers Seq, Length and JSON. An update writes the next Seq to the slot not holding the header,
+// then fsync, a torn update never touches the header. The valid slot with the bigger Seq is the header.
+func EncodeHeaderSlot(seq uint64, body []byte) []byte {
+	buff := make([]byte, HEADER_SLOT_PREFIX_LENGTH+len(body))
+	WriteInt64ToBuff(buff, int64(seq), true)
+	WriteUint32ToBuff(buff[8:], uint32(len(body)), true)
+	copy(buff[HEADER_SLOT_PREFIX_LENGTH:], body)
+	WriteUint32ToBuff(buff[12:], HeaderSlotChecksum(buff[:12], body), true)
+	return buff
+}
+
+//crc32c of Seq, Length and JSON
+func HeaderSlotChecksum(prefix []byte, body []byte) uint32 {
+	crc := crc32.Update(0, Crc32cTable, prefix)
+	return crc32.Update(crc, Crc32cTable, body)
+}
+
+//The seq and JSON of a slot, false if the slot is empty, torn or broken
+func DecodeHeaderSlot(buff []byte) (uint64, []byte, bool) {
+	if len(buff) < HEADER_SLOT_PREFIX_LENGTH {
+		return 0, nil, false
+	}
+	seq := ReadInt64FromBuff(buff, true)
+	length := int64(ReadUint32FromBuff(buff[8:], true))
+	if seq == 0 || length > int64(len(buff)-HEADER_SLOT_PREFIX_LENGTH) {
+		return 0, nil, false
+	}
+	body := buff[HEADER_SLOT_PREFIX_LENGTH : HEADER_SLOT_PREFIX_LENGTH+length]
+	if HeaderSlotChecksum(buff[:12], body) != ReadUint32FromBuff(buff[12:], true) {
+		return 0, nil, false
+	}
+	return seq, body, true
+}
+
+func (aFile *AlfheimDBWALFile) HeaderSlotLength() int64 {
+	return (aFile.HeaderLength - FILE_PREAMBLE_LENGTH) / 2
+}
+
+//pos of the slot for seq, the slots are used in turn
+func (aFile *AlfheimDBWALFile) HeaderSlotPos(seq uint64) int64 {
+	return FILE_PREAMBLE_LENGTH + int64(seq%2)*aFile.HeaderSlotLength()
+}
+
+//Load the header from the valid slot with the bigger seq
+func (aFile *AlfheimDBWALFile) ReadHeaderSlots() error {
+	slotLength := aFile.HeaderSlotLength()
+	buff := make([]byte, 2*slotLength)
+	n, err := ReadFile(*aFile.File, FILE_PREAMBLE_LENGTH, int64(len(buff)), buff)
+	if err != nil {
+		return err
+	}
+	var seq uint64
+	var body []byte
+	var bodyPos int64
+	for slotPos := int64(0); slotPos < n; slotPos = slotPos + slotLength {
+		slotEnd := slotPos + slotLength
+		if slotEnd > n {
+			slotEnd = n
+		}
+		slotSeq, slotBody, ok := DecodeHeaderSlot(buff[slotPos:slotEnd])
+		if ok && slotSeq > seq {
+			seq = slotSeq
+			body = slotBody
+			bodyPos = FILE_PREAMBLE_LENGTH + slotPos + HEADER_SLOT_PREFIX_LENGTH
+		}
+	}
+	if seq == 0 {
+		return NewCorruptError(aFile.Filename, FILE_PREAMBLE_LENGTH, "no valid file header slot")
+	}
+	header, err := DecodeFileHeader(aFile.Filename, bodyPos, body)
+	if err != nil {
+		return err
+	}
+	aFile.Header = header
+	aFile.HeaderSeq = seq
+	return nil
+}
+
+//Write the header JSON to the slot not holding the header, then fsync
+func (aFile *AlfheimDBWALFile) SaveHeaderSlot(body []byte) error {
+	if int64(HEADER_SLOT_PREFIX_LENGTH+len(body)) > aFile.HeaderSlotLength() {
+		return fmt.Errorf("%w: file header of %s is %d bytes, the header slot is %d bytes", ErrHeaderOverflow, aFile.Filename, HEADER_SLOT_PREFIX_LENGTH+len(body), aFile.HeaderSlotLength())
+	}
+	seq := aFile.HeaderSeq + 1
+	err := aFile.WriteHeader(aFile.HeaderSlotPos(seq), EncodeHeaderSlot(seq, body))
+	if err != nil {
+		return err
+	}
+	aFile.HeaderSeq = seq
+	return nil
+}
@@ -0,0 +1,135 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewerLogItemVersionIsUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	closeTestWAL(t, wal)
+
+	//a file written by a newer version
+	aFile, err := NewAlfheimDBWALFile(testLogFiles(t, dir)[0], &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aFile.Header.Version = LOG_ITEM_VERSION + 1
+	err = aFile.SaveFileHeader()
+	aFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewWALWithOptions(dir, opts)
+	if !errors.Is(err, ErrUnsupportedVersion) || errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
+
+func TestTornHeaderSlot(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	err := wal.TruncateLog(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aFile := testLogFile(t, wal, 1)
+	filename, seq := aFile.Filename, aFile.HeaderSeq
+	slotPos := aFile.HeaderSlotPos(seq)
+	closeTestWAL(t, wal)
+
+	//the update of the truncate area is torn, the slot before it wins
+	flipTestFile(t, filename, slotPos+HEADER_SLOT_PREFIX_LENGTH+1, 0xff)
+	wal = openTestWAL(t, dir, opts)
+	checkTestLogs(t, wal, 1, 10)
+	if aFile = testLogFile(t, wal, 1); aFile.HeaderSeq != seq-1 {
+		t.Fatalf("header seq is %d, want %d", aFile.HeaderSeq, seq-1)
+	}
+
+	//the next update overwrites the torn slot
+	err = wal.TruncateLog(3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeTestWAL(t, wal)
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	if aFile = testLogFile(t, wal, 1); aFile.HeaderSeq != seq {
+		t.Fatalf("header seq is %d, want %d", aFile.HeaderSeq, seq)
+	}
+	for _, index := range []int64{1, 2, 4, 10} {
+		_, err = wal.GetLog(index)
+		if err != nil {
+			t.Fatalf("get log %d: %v", index, err)
+		}
+	}
+	_, err = wal.GetLog(3)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get truncated log 3 returns %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestLegacyHeaderIsRewritten(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	closeTestWAL(t, wal)
+
+	//write the header as format version 1: the preamble, then the length prefixed JSON
+	filename := testLogFiles(t, dir)[0]
+	aFile, err := NewAlfheimDBWALFile(filename, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := aFile.MarshalFileHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aFile.Preamble.FormatVersion = FILE_FORMAT_VERSION_1
+	buff := make([]byte, aFile.HeaderLength)
+	copy(buff, aFile.Preamble.Encode())
+	WriteInt64ToBuff(buff[FILE_PREAMBLE_LENGTH:], int64(len(body)), true)
+	copy(buff[FILE_PREAMBLE_LENGTH+8:], body)
+	err = WriteFile(*aFile.File, 0, buff)
+	aFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wal = openTestWAL(t, dir, opts)
+	checkTestLogs(t, wal, 1, 10)
+	if aFile = testLogFile(t, wal, 1); aFile.FormatVersion() != FILE_FORMAT_VERSION_1 {
+		t.Fatalf("file is format version %d, want %d", aFile.FormatVersion(), FILE_FORMAT_VERSION_1)
+	}
+	//the first header update rewrites the file to the current format, not in place
+	err = wal.TruncateLog(5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aFile.FormatVersion() != FILE_FORMAT_VERSION || aFile.RewriteCount != 1 {
+		t.Fatalf("file is format version %d, rewritten %d times, want %d, 1", aFile.FormatVersion(), aFile.RewriteCount, FILE_FORMAT_VERSION)
+	}
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	if aFile = testLogFile(t, wal, 1); aFile.FormatVersion() != FILE_FORMAT_VERSION {
+		t.Fatalf("reopened file is format version %d, want %d", aFile.FormatVersion(), FILE_FORMAT_VERSION)
+	}
+	for index := int64(1); index <= 10; index++ {
+		_, err = wal.GetLog(index)
+		if index == 5 && !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get truncated log 5 returns %v, want %v", err, ErrNotFound)
+		}
+		if index != 5 && err != nil {
+			t.Fatalf("get log %d: %v", index, err)
+		}
+	}
+}
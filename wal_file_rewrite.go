@@ -0,0 +1,276 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	//name prefix of the new file written by Rewrite, it is removed when the wal is opened
+	REWRITE_FILE_PREFIX = "rewrite_"
+	//max bytes Rewrite reads at a time
+	REWRITE_CHUNK_BYTES = 4 << 20
+)
+
+//Truncate logs [start, end] in area ta, the logs must be in the file.
+//The truncate areas are merged, the file is rewritten without the truncated logs if the header overflows.
+func (aFile *AlfheimDBWALFile) TruncateArea(ta *TruncateArea, start, end int64) error {
+	areas := aFile.Header.TruncateArea
+	aFile.Header.TruncateArea = aFile.MergeTruncateAreas(append(areas, ta), start, end)
+	rewritten, err := aFile.SaveHeaderOrRewrite(func() {
+		aFile.Header.TruncateArea = areas
+	}, func(lItem *LogItem) bool {
+		return lItem.Index < start || lItem.Index > end
+	})
+	if err != nil || rewritten {
+		return err
+	}
+	return aFile.Reload()
+}
+
+//Save the header with commitPos. If the truncate areas leave no room for it, the file is rewritten with the logs
+//kept by keep instead, the areas are dropped, and a continued batch part left in the new file is committed.
+//true if the file is rewritten, the logs not kept are gone then, nothing is left to cut at commitPos.
+func (aFile *AlfheimDBWALFile) SaveCommitPos(commitPos int64, keep func(lItem *LogItem) bool) (bool, error) {
+	old := aFile.Header.CommitPos
+	aFile.Header.CommitPos = commitPos
+	rewritten, err := aFile.SaveHeaderOrRewrite(func() {
+		aFile.Header.CommitPos = old
+	}, keep)
+	if err != nil || !rewritten {
+		return false, err
+	}
+	//the new header has no truncate area, the commit pos fits
+	if aFile.ContinuedPos != -1 {
+		aFile.Header.CommitPos = aFile.Pos
+		err = aFile.SaveFileHeader()
+		if err != nil {
+			return true, err
+		}
+		aFile.ContinuedPos = -1
+	}
+	return true, nil
+}
+
+//Save the header changed by the caller, restore undoes the change if the header is not saved.
+//If the truncate areas overflow the header, the file is rewritten with the logs kept by keep instead,
+//the new header has no truncate area. true if the file is rewritten.
+//A file of format version 0 and 1 has one header, a torn update in place loses it, so it is rewritten
+//to the current format by its first header update.
+func (aFile *AlfheimDBWALFile) SaveHeaderOrRewrite(restore func(), keep func(lItem *LogItem) bool) (bool, error) {
+	if aFile.FormatVersion() < FILE_FORMAT_VERSION_2 {
+		restore()
+		aFile.Logger.Infof("File header of %s is format version %d, rewrite the file", aFile.Filename, aFile.FormatVersion())
+		return true, aFile.Rewrite(keep)
+	}
+	err := aFile.SaveFileHeader()
+	if err == nil {
+		return false, nil
+	}
+	restore()
+	if !errors.Is(err, ErrHeaderOverflow) {
+		return false, err
+	}
+	aFile.Logger.Warnf("Truncate areas of %s overflow the file header, rewrite the file", aFile.Filename)
+	return true, aFile.Rewrite(keep)
+}
+
+//Sort the areas and merge the ones overlapped or with no live log between them.
+//The logs [start, end] are being truncated, they are not live.
+func (aFile *AlfheimDBWALFile) MergeTruncateAreas(areas []*TruncateArea, start, end int64) []*TruncateArea {
+	if len(areas) == 0 {
+		return areas
+	}
+	sorted := make([]*TruncateArea, len(areas))
+	copy(sorted, areas)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	//frame pos of the live logs, in order
+	livePos := make([]int64, 0, aFile.LogIndex.Len())
+	for elem := aFile.LogIndex.Front(); elem != nil; elem = elem.Next() {
+		lItem := elem.Value.(*LogItem)
+		if lItem.Index >= start && lItem.Index <= end {
+			continue
+		}
+		livePos = append(livePos, int64(lItem.Pos)-aFile.ItemHeaderLength())
+	}
+	sort.Slice(livePos, func(i, j int) bool {
+		return livePos[i] < livePos[j]
+	})
+	hasLiveLog := func(from, to int64) bool {
+		i := sort.Search(len(livePos), func(i int) bool {
+			return livePos[i] >= from
+		})
+		return i < len(livePos) && livePos[i] < to
+	}
+
+	merged := []*TruncateArea{{Start: sorted[0].Start, End: sorted[0].End}}
+	for _, area := range sorted[1:] {
+		last := merged[len(merged)-1]
+		if area.Start <= last.End || !hasLiveLog(last.End, area.Start) {
+			if area.End > last.End {
+				last.End = area.End
+			}
+			continue
+		}
+		merged = append(merged, &TruncateArea{Start: area.Start, End: area.End})
+	}
+	return merged
+}
+
+//Rewrite the logs kept by keep to a new file, then replace the file by rename, nil keep keeps all logs.
+//Truncate areas, control items and torn bytes are dropped, the new file has a new header of the current version.
+//A batch part continued in the next file is still framed, it is dropped with the rest of the batch if the rest is lost.
+//The caller makes sure the file is not written while rewriting.
+func (aFile *AlfheimDBWALFile) Rewrite(keep func(lItem *LogItem) bool) error {
+	newFile, err := aFile.WriteRewriteFile(keep)
+	if err != nil {
+		return err
+	}
+	err = aFile.RenameRewriteFile(newFile)
+	if err != nil {
+		return err
+	}
+	aFile.Replace(newFile)
+	return SyncDir(filepath.Dir(aFile.Filename))
+}
+
+//Write the logs kept by keep to the synced rewrite_ file of this file.
+//The file is only read, readers can still read it.
+func (aFile *AlfheimDBWALFile) WriteRewriteFile(keep func(lItem *LogItem) bool) (*AlfheimDBWALFile, error) {
+	if aFile.File == nil {
+		return nil, ErrClosed
+	}
+	tmpname := filepath.Join(filepath.Dir(aFile.Filename), REWRITE_FILE_PREFIX+filepath.Base(aFile.Filename))
+	err := os.Remove(tmpname)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, NewIOError("remove", tmpname, err)
+	}
+	newFile := newAlfheimDBWALFile(tmpname, aFile.Options)
+	newFile.CreateTime = aFile.CreateTime
+	newFile.FirstIndex = aFile.FirstIndex
+	err = newFile.BuildLogIndex()
+	if err != nil {
+		os.Remove(tmpname)
+		return nil, err
+	}
+	err = aFile.CopyLogs(newFile, keep)
+	if err == nil {
+		err = newFile.Sync()
+	}
+	if err != nil {
+		newFile.Close()
+		os.Remove(tmpname)
+		return nil, err
+	}
+	return newFile, nil
+}
+
+//Rename newFile written by WriteRewriteFile to the name of this file.
+//The open file still reads the old logs until Replace, the rename is durable after SyncDir.
+func (aFile *AlfheimDBWALFile) RenameRewriteFile(newFile *AlfheimDBWALFile) error {
+	err := os.Rename(newFile.Filename, aFile.Filename)
+	if err != nil {
+		newFile.Close()
+		os.Remove(newFile.Filename)
+		return NewIOError("rename", newFile.Filename, err)
+	}
+	newFile.Filename = aFile.Filename
+	return nil
+}
+
+//Write the logs kept by keep to dst, chunk by chunk
+func (aFile *AlfheimDBWALFile) CopyLogs(dst *AlfheimDBWALFile, keep func(lItem *LogItem) bool) error {
+	plain := make([]*LogItem, 0, aFile.LogIndex.Len())
+	continued := make([]*LogItem, 0)
+	for elem := aFile.LogIndex.Front(); elem != nil; elem = elem.Next() {
+		lItem := elem.Value.(*LogItem)
+		if keep != nil && !keep(lItem) {
+			continue
+		}
+		if aFile.ContinuedPos != -1 && int64(lItem.Pos) > aFile.ContinuedPos {
+			continued = append(continued, lItem)
+		} else {
+			plain = append(plain, lItem)
+		}
+	}
+
+	headerLength := aFile.ItemHeaderLength()
+	for len(plain) != 0 {
+		//the chunk is read in one read, holes in it are read too
+		count := 1
+		startPos := int64(plain[0].Pos) - headerLength
+		for count < len(plain) && int64(plain[count].Pos)+int64(plain[count].Length)-startPos <= REWRITE_CHUNK_BYTES {
+			count++
+		}
+		lItems, data, err := aFile.ReadFrames(plain[:count])
+		if err != nil {
+			return err
+		}
+		err = dst.BatchWriteLogs(lItems, data)
+		if err != nil {
+			return err
+		}
+		plain = plain[count:]
+	}
+	if len(continued) == 0 {
+		return nil
+	}
+	lItems, data, err := aFile.ReadFrames(continued)
+	if err != nil {
+		return err
+	}
+	return dst.BatchWriteLogsAtomic(lItems, data, BATCH_CONTINUE)
+}
+
+//Read lItems and frame them again by the current log item version
+func (aFile *AlfheimDBWALFile) ReadFrames(lItems []*LogItem) ([]*LogItem, []byte, error) {
+	logs, err := aFile.ReadLogs(lItems)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := 0
+	for _, log := range logs {
+		size = size + LOG_ITEM_HEADER_LENGTH + len(log)
+	}
+	buff := make([]byte, size)
+	newItems := make([]*LogItem, len(lItems))
+	pos := 0
+	for i, log := range logs {
+		newItems[i] = NewLogItemBuff(lItems[i].Index, log, buff[pos:], true)
+		pos = pos + LOG_ITEM_HEADER_LENGTH + len(log)
+	}
+	return newItems, buff, nil
+}
+
+//Take the state of newFile, a rewrite of this file with the same name, the old file is closed
+func (aFile *AlfheimDBWALFile) Replace(newFile *AlfheimDBWALFile) {
+	aFile.Close()
+	aFile.File = newFile.File
+	aFile.Pos = newFile.Pos
+	aFile.LogItems = newFile.LogItems
+	aFile.LogIndex = newFile.LogIndex
+	aFile.MaxIndex = newFile.MaxIndex
+	aFile.MinIndex = newFile.MinIndex
+	aFile.Preamble = newFile.Preamble
+	aFile.Header = newFile.Header
+	aFile.HeaderSeq = newFile.HeaderSeq
+	aFile.HeaderLength = newFile.HeaderLength
+	aFile.Dirty = newFile.Dirty
+	aFile.TornBytes = newFile.TornBytes
+	aFile.ContinuedPos = newFile.ContinuedPos
+	aFile.RewriteCount++
+}
+
+//bytes of the logs in the truncate areas
+func (aFile *AlfheimDBWALFile) TruncatedBytes() int64 {
+	var size int64
+	for _, ta := range aFile.Header.TruncateArea {
+		size = size + ta.End - ta.Start
+	}
+	return size
+}
@@ -0,0 +1,51 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRewriteOnHeaderOverflow(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.HeaderLength = MIN_HEADER_LENGTH
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 100)
+
+	//every truncate adds an area, live logs are between them, the header overflows
+	for i := int64(2); i < 100; i = i + 2 {
+		err := wal.TruncateLog(i, i)
+		if err != nil {
+			t.Fatalf("truncate log %d: %v", i, err)
+		}
+	}
+	wal.Mutex.RLock()
+	aFile := wal.FileIndex.Front().Value.(*AlfheimDBWALFile)
+	rewriteCount := aFile.RewriteCount
+	wal.Mutex.RUnlock()
+	if rewriteCount == 0 {
+		t.Fatal("the file is not rewritten when the header overflows")
+	}
+
+	check := func() {
+		t.Helper()
+		for i := int64(1); i <= 100; i++ {
+			data, err := wal.GetLog(i)
+			if i%2 == 0 && i < 100 {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("get truncated log %d returns %v, want %v", i, err, ErrNotFound)
+				}
+				continue
+			}
+			if err != nil || string(data) != string(testData(i)) {
+				t.Fatalf("log %d is %q, %v, want %q", i, data, err, testData(i))
+			}
+		}
+	}
+	check()
+	closeTestWAL(t, wal)
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	check()
+	writeTestLogs(t, wal, 101, 110)
+}
@@ -0,0 +1,51 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChecksumMismatchOnRead(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 10)
+
+	//a bit of the data of log 5 is flipped
+	filename, framePos := testLogFrame(t, wal, 5)
+	flipTestFile(t, filename, framePos+LOG_ITEM_HEADER_LENGTH, 0x01)
+	_, err := wal.GetLog(5)
+	var corruptErr *CorruptError
+	if !errors.As(err, &corruptErr) {
+		t.Fatalf("get log 5 returns %v, want %v", err, ErrCorrupt)
+	}
+	if corruptErr.Filename != filename || corruptErr.Offset != framePos {
+		t.Fatalf("corrupt at %d of %s, want %d of %s", corruptErr.Offset, corruptErr.Filename, framePos, filename)
+	}
+	_, err = wal.GetLogs(1, 10, 0)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("get logs [1, 10] returns %v, want %v", err, ErrCorrupt)
+	}
+	//the other logs are still read
+	for _, index := range []int64{4, 6} {
+		data, err := wal.GetLog(index)
+		if err != nil || string(data) != string(testData(index)) {
+			t.Fatalf("log %d is %q, %v, want %q", index, data, err, testData(index))
+		}
+	}
+}
+
+func TestChecksumCoversLength(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	filename, framePos := testLogFrame(t, wal, 3)
+	closeTestWAL(t, wal)
+
+	//a bit of the length of log 3 is flipped, the logs after it are durable, it is not a torn write
+	flipTestFile(t, filename, framePos+7, 0x01)
+	_, err := NewWALWithOptions(dir, opts)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrCorrupt)
+	}
+}
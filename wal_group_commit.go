@@ -0,0 +1,139 @@
+package alfheimdbwal
+
+const (
+	//max write requests merged into one group
+	GROUP_COMMIT_MAX_REQUESTS = 1024
+	//max bytes merged into one group, a single bigger request is not split
+	GROUP_COMMIT_MAX_BYTES = 4 << 20
+)
+
+//a write waiting for group commit
+type CommitRequest struct {
+	LItems []*LogItem
+	Data   []byte
+	//receives nil when the logs are durable
+	Done chan error
+}
+
+//Queue the logs to the committer, return when they are written and synced.
+//Concurrent writers are merged into one write and one fsync.
+func (wal *AlfheimDBWAL) GroupCommit(lItems []*LogItem, data []byte) error {
+	req := &CommitRequest{LItems: lItems, Data: data, Done: make(chan error, 1)}
+	select {
+	case wal.commitChan <- req:
+	case <-wal.commitStop:
+		return ErrClosed
+	}
+	select {
+	case err := <-req.Done:
+		return err
+	case <-wal.commitDone:
+		//the committer exited, the request may be left in the queue
+		select {
+		case err := <-req.Done:
+			return err
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+func (wal *AlfheimDBWAL) StartGroupCommit() {
+	if wal.Options.SyncPolicy != SyncGroupCommit {
+		return
+	}
+	wal.commitChan = make(chan *CommitRequest, GROUP_COMMIT_MAX_REQUESTS)
+	wal.commitStop = make(chan struct{})
+	wal.commitDone = make(chan struct{})
+	wal.wg.Add(1)
+	go func() {
+		defer wal.wg.Done()
+		defer close(wal.commitDone)
+		for {
+			select {
+			case req := <-wal.commitChan:
+				wal.CommitGroup(wal.CollectGroup(req))
+			case <-wal.commitStop:
+				//fail the requests already queued
+				for {
+					select {
+					case req := <-wal.commitChan:
+						req.Done <- ErrClosed
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+//take the queued requests after first, until the group is full
+func (wal *AlfheimDBWAL) CollectGroup(first *CommitRequest) []*CommitRequest {
+	reqs := []*CommitRequest{first}
+	size := len(first.Data)
+	for len(reqs) < GROUP_COMMIT_MAX_REQUESTS && size < GROUP_COMMIT_MAX_BYTES {
+		select {
+		case req := <-wal.commitChan:
+			reqs = append(reqs, req)
+			size = size + len(req.Data)
+		default:
+			return reqs
+		}
+	}
+	return reqs
+}
+
+//write the group as one batch, fsync once, then release every writer.
+//A request with bad data, or out of order in strict index mode, fails alone, the others are written.
+func (wal *AlfheimDBWAL) CommitGroup(reqs []*CommitRequest) {
+	wal.writeMutex.Lock()
+	errs := make([]error, len(reqs))
+	valid := make([]*CommitRequest, 0, len(reqs))
+	next := wal.NextAppendIndex()
+	for i, req := range reqs {
+		errs[i] = CheckAppendData(req.LItems, req.Data)
+		if errs[i] == nil && wal.Options.StrictIndex {
+			errs[i] = CheckAppendIndex(req.LItems, next)
+		}
+		if errs[i] == nil {
+			valid = append(valid, req)
+			next = req.LItems[len(req.LItems)-1].Index + 1
+		}
+	}
+
+	var err error
+	if len(valid) > 0 {
+		lItems := valid[0].LItems
+		data := valid[0].Data
+		if len(valid) > 1 {
+			size := 0
+			count := 0
+			for _, req := range valid {
+				size = size + len(req.Data)
+				count = count + len(req.LItems)
+			}
+			lItems = make([]*LogItem, 0, count)
+			data = make([]byte, 0, size)
+			for _, req := range valid {
+				lItems = append(lItems, req.LItems...)
+				data = append(data, req.Data...)
+			}
+		}
+		wal.Mutex.Lock()
+		err = wal.AppendLogs(lItems, data)
+		wal.Mutex.Unlock()
+		if err == nil {
+			err = wal.SyncFiles()
+		}
+	}
+	wal.writeMutex.Unlock()
+
+	for i, req := range reqs {
+		if errs[i] != nil {
+			req.Done <- errs[i]
+		} else {
+			req.Done <- err
+		}
+	}
+}
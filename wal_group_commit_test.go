@@ -0,0 +1,86 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestGroupCommitConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SyncPolicy = SyncGroupCommit
+	wal := openTestWAL(t, dir, opts)
+	//the file is found by its first log, the writers append after it in any order
+	writeTestLogs(t, wal, 1, 1)
+
+	//writer w writes the logs [w*50+2, w*50+51], single logs and batches
+	const writers = 16
+	var wg sync.WaitGroup
+	for w := int64(0); w < writers; w++ {
+		wg.Add(1)
+		go func(first int64) {
+			defer wg.Done()
+			//a batch of 5 logs, then 5 single logs
+			for i := first; i < first+50; i = i + 10 {
+				lItems, data := testLogs(i, i+4)
+				err := wal.BatchWriteLog(lItems, data)
+				if err != nil {
+					t.Errorf("batch write logs [%d, %d]: %v", i, i+4, err)
+					return
+				}
+				for j := i + 5; j < i+10; j++ {
+					lItems, data := testLogs(j, j)
+					err = wal.WriteLog(lItems[0], data)
+					if err != nil {
+						t.Errorf("write log %d: %v", j, err)
+						return
+					}
+				}
+			}
+		}(w*50 + 2)
+	}
+	wg.Wait()
+	if n := dirtyTestFiles(wal); n != 0 {
+		t.Fatalf("%d files are not synced after the writers return", n)
+	}
+	checkTestLogs(t, wal, 1, writers*50+1)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, writers*50+1)
+}
+
+func TestGroupCommitFailsBadRequestAlone(t *testing.T) {
+	opts := testOptions()
+	opts.SyncPolicy = SyncGroupCommit
+	opts.StrictIndex = true
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 3)
+
+	request := func(from, to int64) *CommitRequest {
+		lItems, data := testLogs(from, to)
+		return &CommitRequest{LItems: lItems, Data: data, Done: make(chan error, 1)}
+	}
+	//the data of the second request is short, the next index is still 6 after it
+	reqs := []*CommitRequest{request(4, 5), request(6, 6), request(6, 7), request(9, 9)}
+	reqs[1].Data = reqs[1].Data[:len(reqs[1].Data)-1]
+	wal.CommitGroup(reqs)
+
+	errs := make([]error, len(reqs))
+	for i, req := range reqs {
+		errs[i] = <-req.Done
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Fatalf("valid requests fail by %v, %v", errs[0], errs[2])
+	}
+	if errs[1] == nil {
+		t.Fatal("request with short data is written")
+	}
+	if !errors.Is(errs[3], ErrOutOfOrder) {
+		t.Fatalf("request of log 9 returns %v, want %v", errs[3], ErrOutOfOrder)
+	}
+	checkTestLogs(t, wal, 1, 7)
+}
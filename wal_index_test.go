@@ -0,0 +1,75 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStrictIndexRejectsOutOfOrder(t *testing.T) {
+	opts := testOptions()
+	opts.StrictIndex = true
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 100, 110)
+
+	for _, index := range []int64{110, 112, 99} {
+		lItems, data := testLogs(index, index)
+		err := wal.BatchWriteLog(lItems, data)
+		if !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("write log %d returns %v, want %v", index, err, ErrOutOfOrder)
+		}
+	}
+	err := wal.TruncateLog(103, 105)
+	if !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("truncate a middle range returns %v, want %v", err, ErrOutOfOrder)
+	}
+	checkTestLogs(t, wal, 100, 110)
+
+	//a range touching an end keeps the logs contiguous
+	err = wal.TruncateLog(100, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wal.TruncateLog(108, 115)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestLogs(t, wal, 103, 107)
+	writeTestLogs(t, wal, 108, 109)
+	checkTestLogs(t, wal, 103, 109)
+}
+
+func TestOverwriteTruncatesSuffix(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	opts.StrictIndex = true
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 20)
+
+	lItems, data := testLogs(8, 10)
+	err := wal.Overwrite(lItems, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestLogs(t, wal, 1, 10)
+	for _, index := range []int64{12, 0} {
+		lItems, data := testLogs(index, index)
+		err = wal.Overwrite(lItems, data)
+		if !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("overwrite from %d returns %v, want %v", index, err, ErrOutOfOrder)
+		}
+	}
+	//all logs are replaced
+	lItems, data = testLogs(1, 3)
+	err = wal.Overwrite(lItems, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestLogs(t, wal, 1, 3)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 3)
+}
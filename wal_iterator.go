@@ -0,0 +1,237 @@
+package alfheimdbwal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+//read buffer of the iterator
+const ITERATOR_BUFFER_SIZE = 1 << 20
+
+//Iterator reads logs [from, to] in order, file by file, with a big sequential buffer.
+//Logs appended after the iterator is created are read if they are in range.
+//A file truncated while being read is loaded again from the next log, Next fails by ErrOutOfRange
+//if the next log is truncated, Err returns the error. A file compacted while being read is loaded
+//again from the next log too.
+//
+//	it := wal.NewIterator(from, to)
+//	defer it.Close()
+//	for it.Next() {
+//		apply(it.Index(), it.Data())
+//	}
+//	if it.Err() != nil {
+//	}
+type Iterator struct {
+	WAL  *AlfheimDBWAL
+	From int64
+	To   int64
+
+	//the next index to load
+	next int64
+	//logs of the current file to read, and the reader at readerPos of the file
+	aFile     *AlfheimDBWALFile
+	lItems    []*LogItem
+	reader    *bufio.Reader
+	readerPos int64
+	//aFile.RewriteCount, aFile.TruncateCount, version and item header length when the file is loaded,
+	//aFile.Header is changed by rewrite under wal.Mutex, Next does not read it
+	rewriteCount  int64
+	truncateCount int64
+	version      int
+	headerLength int64
+
+	index  int64
+	data   []byte
+	err    error
+	closed bool
+}
+
+//iterate logs [from, to]
+func (wal *AlfheimDBWAL) NewIterator(from, to int64) *Iterator {
+	return &Iterator{WAL: wal, From: from, To: to, next: from, index: -1}
+}
+
+//Move to the next log, false if no more logs, or an error happens
+func (it *Iterator) Next() bool {
+	if it.closed || it.err != nil {
+		return false
+	}
+	for len(it.lItems) == 0 {
+		if !it.loadFile() {
+			return false
+		}
+	}
+	lItem := it.lItems[0]
+	it.lItems = it.lItems[1:]
+
+	//skip control items and truncated logs before this one
+	headerLength := it.headerLength
+	framePos := int64(lItem.Pos) - headerLength
+	_, err := it.reader.Discard(int(framePos - it.readerPos))
+	if err != nil {
+		if it.truncated() {
+			return it.reloadTruncated(lItem)
+		}
+		return it.retryRewritten(lItem, framePos, err)
+	}
+	frame := make([]byte, headerLength+int64(lItem.Length))
+	_, err = io.ReadFull(it.reader, frame)
+	//the frame may be read from the buffer after the log is truncated, or cut by the truncate
+	if it.truncated() {
+		return it.reloadTruncated(lItem)
+	}
+	if err != nil {
+		return it.retryRewritten(lItem, framePos, err)
+	}
+	it.readerPos = framePos + int64(len(frame))
+	err = it.aFile.CheckLogItem(it.version, framePos, frame, lItem)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.index = lItem.Index
+	it.data = frame[headerLength:]
+	return true
+}
+
+//Load the logs in range from the file which has it.next, or the files after it.
+//false if no more logs.
+func (it *Iterator) loadFile() bool {
+	wal := it.WAL
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		it.err = ErrClosed
+		return false
+	}
+
+	if it.next > it.To || wal.FileIndex.Len() == 0 {
+		return false
+	}
+	elem := wal.FindFileElem(it.next)
+	if elem == nil {
+		elem = wal.FileIndex.Front()
+	}
+	for ; elem != nil; elem = elem.Next() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.File == nil {
+			it.err = ErrClosed
+			return false
+		}
+		lItems := make([]*LogItem, 0)
+		for lElem := aFile.LogIndex.Find(it.next); lElem != nil; lElem = lElem.Next() {
+			lItem := lElem.Value.(*LogItem)
+			if lItem.Index > it.To {
+				break
+			}
+			lItems = append(lItems, lItem)
+		}
+		if len(lItems) == 0 {
+			continue
+		}
+
+		first := lItems[0]
+		last := lItems[len(lItems)-1]
+		headerLength := aFile.ItemHeaderLength()
+		it.readerPos = int64(first.Pos) - headerLength
+		end := int64(last.Pos) + int64(last.Length)
+		section := io.NewSectionReader(aFile.File, it.readerPos, end-it.readerPos)
+		//a small range needs no big buffer, the buffer is reused by the next files
+		size := end - it.readerPos
+		if size > ITERATOR_BUFFER_SIZE {
+			size = ITERATOR_BUFFER_SIZE
+		}
+		if it.reader == nil || int64(it.reader.Size()) < size {
+			it.reader = bufio.NewReaderSize(section, int(size))
+		} else {
+			it.reader.Reset(section)
+		}
+		it.aFile = aFile
+		it.lItems = lItems
+		it.rewriteCount = aFile.RewriteCount
+		it.truncateCount = atomic.LoadInt64(&aFile.TruncateCount)
+		it.version = aFile.Version()
+		it.headerLength = headerLength
+		it.next = last.Index + 1
+		return true
+	}
+	return false
+}
+
+//The read of lItem fails by err, if the file is rewritten since it is loaded, the old file is closed,
+//load the file again from lItem, or else Next fails by err.
+func (it *Iterator) retryRewritten(lItem *LogItem, framePos int64, err error) bool {
+	if errors.Is(err, os.ErrClosed) {
+		it.WAL.Mutex.RLock()
+		rewritten := !it.WAL.closed && it.aFile.RewriteCount != it.rewriteCount
+		it.WAL.Mutex.RUnlock()
+		if rewritten {
+			it.next = lItem.Index
+			it.lItems = nil
+			return it.Next()
+		}
+	}
+	it.err = it.readError(framePos, err)
+	return false
+}
+
+//true if the logs of the file are truncated since it is loaded, see MarkTruncated
+func (it *Iterator) truncated() bool {
+	return atomic.LoadInt64(&it.aFile.TruncateCount) != it.truncateCount
+}
+
+//The file is truncated while lItem is read, what is read may be gone, load the file again from lItem.
+//Next fails by ErrOutOfRange if lItem is truncated.
+func (it *Iterator) reloadTruncated(lItem *LogItem) bool {
+	it.next = lItem.Index
+	it.lItems = nil
+	if !it.loadFile() {
+		if it.err == nil {
+			it.err = fmt.Errorf("%w: log %d is truncated while being read", ErrOutOfRange, lItem.Index)
+		}
+		return false
+	}
+	if it.lItems[0].Index != lItem.Index {
+		it.err = fmt.Errorf("%w: log %d is truncated while being read", ErrOutOfRange, lItem.Index)
+		return false
+	}
+	return it.Next()
+}
+
+func (it *Iterator) readError(framePos int64, err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return ErrClosed
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return NewCorruptError(it.aFile.Filename, framePos, fmt.Sprintf("read log: %v", err))
+	}
+	return NewIOError("read", it.aFile.Filename, err)
+}
+
+//index of the current log
+func (it *Iterator) Index() int64 {
+	return it.index
+}
+
+//data of the current log, it is not reused by Next
+func (it *Iterator) Data() []byte {
+	return it.data
+}
+
+//the error stopped Next, nil if all logs in range are read
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Close() error {
+	it.closed = true
+	it.aFile = nil
+	it.lItems = nil
+	it.reader = nil
+	it.data = nil
+	return nil
+}
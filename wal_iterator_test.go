@@ -0,0 +1,106 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestIteratorWithTruncateAndCompact(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxItems = 100
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 300)
+
+	//the logs after 50 of each file are truncated and compacted while [1, 50] of the files are read
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(51); i <= 100; i++ {
+			for _, index := range []int64{i, i + 100, i + 200} {
+				err := wal.TruncateLog(index, index)
+				if err != nil {
+					t.Errorf("truncate log %d: %v", index, err)
+					return
+				}
+			}
+			if i%10 == 0 {
+				err := wal.Compact()
+				if err != nil {
+					t.Errorf("compact: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	for round := 0; round < 20; round++ {
+		for _, from := range []int64{1, 101, 201} {
+			it := wal.NewIterator(from, from+49)
+			next := from
+			for it.Next() {
+				if it.Index() != next || string(it.Data()) != string(testData(next)) {
+					t.Fatalf("iterator reads log %d %q, want %d", it.Index(), it.Data(), next)
+				}
+				next++
+			}
+			if it.Err() != nil {
+				t.Fatalf("iterate from %d: %v", from, it.Err())
+			}
+			if next != from+50 {
+				t.Fatalf("iterator stops at %d, want %d", next, from+50)
+			}
+			it.Close()
+		}
+	}
+	wg.Wait()
+}
+
+func TestIteratorWithTruncateBackAndFront(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 300)
+
+	//the logs in the buffer are truncated after the logs [from, read] are read,
+	//the iterator reads on to last, and fails if the log after read is truncated
+	cases := []struct {
+		name     string
+		from     int64
+		read     int64
+		truncate func() error
+		last     int64
+		err      error
+	}{
+		{"truncate back after the next log", 1, 1, func() error { return wal.TruncateBack(200) }, 200, nil},
+		{"truncate back the next log", 1, 20, func() error { return wal.TruncateBack(10) }, 20, ErrOutOfRange},
+		{"truncate front the next log", 3, 3, func() error { return wal.TruncateFront(5) }, 3, ErrOutOfRange},
+	}
+	for _, c := range cases {
+		it := wal.NewIterator(c.from, 300)
+		next := c.from
+		for next <= c.read && it.Next() {
+			next++
+		}
+		if next != c.read+1 {
+			t.Fatalf("%s: iterator stops at %d: %v", c.name, next, it.Err())
+		}
+		err := c.truncate()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		for it.Next() {
+			if it.Index() != next || string(it.Data()) != string(testData(next)) {
+				t.Fatalf("%s: iterator reads log %d %q, want %d", c.name, it.Index(), it.Data(), next)
+			}
+			next++
+		}
+		if !errors.Is(it.Err(), c.err) {
+			t.Fatalf("%s: iterator stops by %v, want %v", c.name, it.Err(), c.err)
+		}
+		if next != c.last+1 {
+			t.Fatalf("%s: iterator stops at %d, want %d", c.name, next, c.last+1)
+		}
+		it.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package alfheimdbwal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//the lock file in wal dir, a writer locks it exclusively, a read only wal locks it shared
+const LOCK_FILENAME = "LOCK"
+
+type DirLock struct {
+	Filename string
+	File     *os.File
+	Shared   bool
+}
+
+//Lock the wal dir, ErrLocked if it is locked by another wal.
+//A shared lock creates the lock file too if no writer has created it, so a writer can not open the dir under it.
+func LockDir(dirname string, shared bool, perm os.FileMode) (*DirLock, error) {
+	filename := filepath.Join(dirname, LOCK_FILENAME)
+	flag := os.O_RDWR | os.O_CREATE
+	if shared {
+		flag = os.O_RDONLY | os.O_CREATE
+	}
+	file, err := os.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, NewIOError("open", filename, err)
+	}
+	err = lockFile(file, shared)
+	if err != nil {
+		file.Close()
+		if err == ErrLocked {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, dirname)
+		}
+		return nil, NewIOError("lock", filename, err)
+	}
+	return &DirLock{Filename: filename, File: file, Shared: shared}, nil
+}
+
+//release the lock, the lock file is kept
+func (l *DirLock) Unlock() error {
+	if l == nil || l.File == nil {
+		return nil
+	}
+	err := unlockFile(l.File)
+	closeErr := l.File.Close()
+	l.File = nil
+	if err != nil {
+		return NewIOError("unlock", l.Filename, err)
+	}
+	if closeErr != nil {
+		return NewIOError("close", l.Filename, closeErr)
+	}
+	return nil
+}
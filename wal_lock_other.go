@@ -0,0 +1,52 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package alfheimdbwal
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//No flock on this platform, the lock only works in this process.
+var (
+	lockedMutex sync.Mutex
+	//lock file name => shared lock count, -1 is locked exclusively
+	lockedFiles = make(map[string]int)
+)
+
+func lockFile(file *os.File, shared bool) error {
+	name, err := filepath.Abs(file.Name())
+	if err != nil {
+		return err
+	}
+	lockedMutex.Lock()
+	defer lockedMutex.Unlock()
+	count := lockedFiles[name]
+	if count < 0 || (count > 0 && !shared) {
+		return ErrLocked
+	}
+	if shared {
+		lockedFiles[name] = count + 1
+	} else {
+		lockedFiles[name] = -1
+	}
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	name, err := filepath.Abs(file.Name())
+	if err != nil {
+		return err
+	}
+	lockedMutex.Lock()
+	defer lockedMutex.Unlock()
+	count := lockedFiles[name]
+	if count > 1 {
+		lockedFiles[name] = count - 1
+	} else {
+		delete(lockedFiles, name)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockedDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+
+	_, err := NewWALWithOptions(dir, opts)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("open a locked dir returns %v, want %v", err, ErrLocked)
+	}
+	readOnly := opts
+	readOnly.ReadOnly = true
+	_, err = NewWALWithOptions(dir, readOnly)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("open a locked dir read only returns %v, want %v", err, ErrLocked)
+	}
+	closeTestWAL(t, wal)
+
+	//the read only wals lock the dir shared, even if no writer has created the lock file
+	err = os.Remove(filepath.Join(dir, LOCK_FILENAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readers := make([]*AlfheimDBWAL, 2)
+	for i := range readers {
+		readers[i] = openTestWAL(t, dir, readOnly)
+		checkTestLogs(t, readers[i], 1, 3)
+	}
+	_, err = NewWALWithOptions(dir, opts)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("open a dir locked by read only wals returns %v, want %v", err, ErrLocked)
+	}
+	for _, reader := range readers {
+		closeTestWAL(t, reader)
+	}
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 3)
+}
@@ -0,0 +1,162 @@
+package alfheimdbwal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	//the wal meta file in wal dir, keeps the options which can not change after files are written
+	META_FILENAME = "META"
+	//min file header length, the preamble and two header slots with a small JSON header
+	MIN_HEADER_LENGTH = 256
+)
+
+type Options struct {
+	//a new file is created when the last file has SegmentMaxItems logs
+	SegmentMaxItems int64
+	//a new file is created when the last file reaches SegmentMaxBytes, 0 is no limit
+	SegmentMaxBytes int64
+	//a new file is created when the last file is created SegmentMaxAge ago, 0 is no limit
+	SegmentMaxAge time.Duration
+	//file header length of new files, can not change after the wal is created
+	HeaderLength int64
+	SyncPolicy   SyncPolicy
+	//fsync period of SyncInterval
+	SyncInterval time.Duration
+	//permission of new files
+	FileMode os.FileMode
+	//nil is logrus.StandardLogger()
+	Logger logrus.FieldLogger
+	//open files read only, writes and truncates return ErrReadOnly
+	ReadOnly bool
+	//a file is compacted in background when its truncated bytes are CompactRatio of its log bytes, 0 is never
+	CompactRatio float64
+	//period of the background compaction, 0 is never
+	CompactInterval time.Duration
+	//the appended logs must continue the last log one by one, or ErrOutOfOrder, see Overwrite
+	StrictIndex bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		SegmentMaxItems: 1000,
+		SegmentMaxBytes: 64 << 20,
+		HeaderLength:    1 << 10,
+		SyncPolicy:      SyncAlways,
+		SyncInterval:    100 * time.Millisecond,
+		FileMode:        0644,
+		Logger:          logrus.StandardLogger(),
+		CompactRatio:    0.5,
+		CompactInterval: time.Minute,
+	}
+}
+
+func (opts *Options) Validate() error {
+	if opts.SegmentMaxItems <= 0 {
+		return fmt.Errorf("%w: segment max items must be positive, got %d", ErrInvalidOptions, opts.SegmentMaxItems)
+	}
+	if opts.HeaderLength < MIN_HEADER_LENGTH {
+		return fmt.Errorf("%w: header length must be at least %d, got %d", ErrInvalidOptions, MIN_HEADER_LENGTH, opts.HeaderLength)
+	}
+	if opts.SegmentMaxBytes < 0 || (opts.SegmentMaxBytes > 0 && opts.SegmentMaxBytes <= opts.HeaderLength) {
+		return fmt.Errorf("%w: segment max bytes must be 0 or greater than header length %d, got %d", ErrInvalidOptions, opts.HeaderLength, opts.SegmentMaxBytes)
+	}
+	if opts.SegmentMaxAge < 0 {
+		return fmt.Errorf("%w: segment max age must not be negative, got %s", ErrInvalidOptions, opts.SegmentMaxAge)
+	}
+	switch opts.SyncPolicy {
+	case SyncAlways, SyncNever, SyncGroupCommit:
+	case SyncInterval:
+		if opts.SyncInterval <= 0 {
+			return fmt.Errorf("%w: sync interval must be positive, got %s", ErrInvalidOptions, opts.SyncInterval)
+		}
+	default:
+		return fmt.Errorf("%w: unknow sync policy %d", ErrInvalidOptions, opts.SyncPolicy)
+	}
+	if opts.CompactRatio < 0 || opts.CompactRatio > 1 {
+		return fmt.Errorf("%w: compact ratio must be in [0, 1], got %g", ErrInvalidOptions, opts.CompactRatio)
+	}
+	if opts.CompactInterval < 0 {
+		return fmt.Errorf("%w: compact interval must not be negative, got %s", ErrInvalidOptions, opts.CompactInterval)
+	}
+	if opts.FileMode.Perm() == 0 {
+		return fmt.Errorf("%w: file mode %s has no permission", ErrInvalidOptions, opts.FileMode)
+	}
+	return nil
+}
+
+//The options and the state persisted in the wal dir.
+//Reopen the wal with different options is ErrIncompatibleOptions.
+type WALMeta struct {
+	HeaderLength int64 `json:"header_length"`
+	IsBigEndian  bool  `json:"is_big_endian"`
+	//the next index set by Reset, it is the first index when the wal is empty
+	NextIndex int64 `json:"next_index,omitempty"`
+	//the files a Reset is removing, they are removed when the wal is opened if it crashed
+	RemoveFiles []string `json:"remove_files,omitempty"`
+}
+
+//Check the options with the meta in wal dir, create the meta if the wal dir has none.
+//A wal dir without meta is written by an old version, it's header length is 1K.
+func (wal *AlfheimDBWAL) LoadMeta(hasFiles bool) error {
+	meta, err := ReadMeta(wal.Dirname)
+	if err != nil {
+		return err
+	}
+	saved := meta != nil
+	if !saved {
+		meta = new(WALMeta)
+		if hasFiles {
+			meta.HeaderLength = 1 << 10
+			meta.IsBigEndian = true
+		} else {
+			meta.HeaderLength = wal.Options.HeaderLength
+			meta.IsBigEndian = wal.IsBigEndian
+		}
+	}
+
+	if meta.HeaderLength != wal.Options.HeaderLength {
+		return fmt.Errorf("%w: header length is %d, the wal is created with %d", ErrIncompatibleOptions, wal.Options.HeaderLength, meta.HeaderLength)
+	}
+	if meta.IsBigEndian != wal.IsBigEndian {
+		return fmt.Errorf("%w: the wal is created with big endian %t", ErrIncompatibleOptions, meta.IsBigEndian)
+	}
+	wal.Meta = meta
+	if saved || wal.Options.ReadOnly {
+		return nil
+	}
+	return wal.SaveMeta()
+}
+
+//Read the meta in wal dir, nil if the wal dir has none
+func ReadMeta(dirname string) (*WALMeta, error) {
+	filename := filepath.Join(dirname, META_FILENAME)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewIOError("read", filename, err)
+	}
+	meta := new(WALMeta)
+	err = json.Unmarshal(b, meta)
+	if err != nil {
+		return nil, NewCorruptError(filename, 0, fmt.Sprintf("load wal meta: %v", err))
+	}
+	return meta, nil
+}
+
+func (wal *AlfheimDBWAL) SaveMeta() error {
+	b, err := json.Marshal(wal.Meta)
+	if err != nil {
+		return fmt.Errorf("save wal meta: %w", err)
+	}
+	return WriteFileAtomic(filepath.Join(wal.Dirname, META_FILENAME), b, wal.Options.FileMode)
+}
@@ -0,0 +1,57 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateOptions(t *testing.T) {
+	opts := testOptions()
+	err := opts.Validate()
+	if err != nil {
+		t.Fatalf("default options are invalid: %v", err)
+	}
+	invalid := map[string]func(opts *Options){
+		"zero segment max items":      func(opts *Options) { opts.SegmentMaxItems = 0 },
+		"small header length":         func(opts *Options) { opts.HeaderLength = MIN_HEADER_LENGTH - 1 },
+		"negative segment bytes":      func(opts *Options) { opts.SegmentMaxBytes = -1 },
+		"segment bytes in header":     func(opts *Options) { opts.SegmentMaxBytes = opts.HeaderLength },
+		"negative segment age":        func(opts *Options) { opts.SegmentMaxAge = -time.Second },
+		"unknow sync policy":          func(opts *Options) { opts.SyncPolicy = 100 },
+		"zero sync interval":          func(opts *Options) { opts.SyncPolicy, opts.SyncInterval = SyncInterval, 0 },
+		"compact ratio above 1":       func(opts *Options) { opts.CompactRatio = 1.5 },
+		"negative compact period":     func(opts *Options) { opts.CompactInterval = -time.Second },
+		"file mode has no permission": func(opts *Options) { opts.FileMode = 0 },
+	}
+	for name, change := range invalid {
+		opts := testOptions()
+		change(&opts)
+		_, err := NewWALWithOptions(t.TempDir(), opts)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: open wal returns %v, want %v", name, err, ErrInvalidOptions)
+		}
+	}
+}
+
+func TestIncompatibleOptions(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.HeaderLength = 512
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	closeTestWAL(t, wal)
+
+	_, err := NewWALWithOptions(dir, testOptions())
+	if !errors.Is(err, ErrIncompatibleOptions) {
+		t.Fatalf("open wal with another header length returns %v, want %v", err, ErrIncompatibleOptions)
+	}
+	//the other options may change
+	opts.SegmentMaxItems = 2
+	opts.SyncPolicy = SyncNever
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 3)
+	writeTestLogs(t, wal, 4, 6)
+	checkTestLogs(t, wal, 1, 6)
+}
@@ -0,0 +1,111 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRepairTornTail(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	closeTestWAL(t, wal)
+
+	//the last log is torn in its data
+	chopTestFile(t, testLogFiles(t, dir)[0], 3)
+	wal = openTestWAL(t, dir, opts)
+	checkTestLogs(t, wal, 1, 9)
+	report := wal.RecoveryReport.TruncatedFiles
+	if len(report) != 1 || report[0].TruncatedBytes == 0 {
+		t.Fatalf("recovery report is %v, want one truncated file", report)
+	}
+	writeTestLogs(t, wal, 10, 12)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 12)
+	if len(wal.RecoveryReport.TruncatedFiles) != 0 {
+		t.Fatalf("recovery report is %v after repair, want empty", wal.RecoveryReport.TruncatedFiles)
+	}
+}
+
+func TestRepairTornLogHeader(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	closeTestWAL(t, wal)
+
+	//a part of the next log item header is written
+	filename := testLogFiles(t, dir)[0]
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 7, 0, 0})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 10)
+	writeTestLogs(t, wal, 11, 11)
+	checkTestLogs(t, wal, 1, 11)
+}
+
+func TestDirtySealedFileIsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 8)
+	closeTestWAL(t, wal)
+
+	//a torn write is only in the last file
+	chopTestFile(t, testLogFiles(t, dir)[0], 3)
+	_, err := NewWALWithOptions(dir, opts)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestBrokenLengthIsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	filename, framePos := testLogFrame(t, wal, 3)
+	closeTestWAL(t, wal)
+
+	//the length of log 3 is out of the file size, like a torn write, but logs 4-10 are whole after it
+	flipTestFile(t, filename, framePos+1, 0x10)
+	_, err := NewWALWithOptions(dir, opts)
+	var corruptErr *CorruptError
+	if !errors.As(err, &corruptErr) {
+		t.Fatalf("open wal returns %v, want %v", err, ErrCorrupt)
+	}
+	if corruptErr.Offset != framePos {
+		t.Fatalf("corrupt at %d, want %d", corruptErr.Offset, framePos)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the file is not repaired
+	flipTestFile(t, filename, framePos+1, 0x10)
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 10)
+	repaired, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.Size() != info.Size() {
+		t.Fatalf("file size is %d after open, want %d", repaired.Size(), info.Size())
+	}
+}
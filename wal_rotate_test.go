@@ -0,0 +1,100 @@
+package alfheimdbwal
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//every log file of dir is at most size bytes, the number of files is returned
+func checkTestFileSizes(t *testing.T, dir string, size int64) int {
+	t.Helper()
+	filenames := testLogFiles(t, dir)
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > size {
+			t.Fatalf("file %s is %d bytes, more than %d", filename, info.Size(), size)
+		}
+	}
+	return len(filenames)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxBytes = opts.HeaderLength + 300
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 50)
+	closeTestWAL(t, wal)
+	if n := checkTestFileSizes(t, dir, opts.SegmentMaxBytes); n < 5 {
+		t.Fatalf("%d files, want at least 5", n)
+	}
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 50)
+}
+
+func TestSplitBatchBySize(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxBytes = opts.HeaderLength + 300
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 3)
+	batchTestLogs(t, wal, 4, 50)
+	closeTestWAL(t, wal)
+	if n := checkTestFileSizes(t, dir, opts.SegmentMaxBytes); n < 5 {
+		t.Fatalf("%d files, want at least 5", n)
+	}
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 50)
+}
+
+func TestBigLogGetsOwnFile(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxBytes = opts.HeaderLength + 300
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 2)
+	big := make([]byte, 400)
+	buff := make([]byte, LOG_ITEM_HEADER_LENGTH+len(big))
+	err := wal.WriteLog(NewLogItemBuff(3, big, buff, true), buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLogs(t, wal, 4, 4)
+
+	wal.Mutex.RLock()
+	files := wal.FileIndex.Len()
+	wal.Mutex.RUnlock()
+	if files != 3 {
+		t.Fatalf("%d files, want 3", files)
+	}
+	data, err := wal.GetLog(3)
+	if err != nil || len(data) != len(big) {
+		t.Fatalf("big log is %d bytes, %v, want %d bytes", len(data), err, len(big))
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxAge = 100 * time.Millisecond
+	wal := openTestWAL(t, dir, opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 2)
+	time.Sleep(2 * opts.SegmentMaxAge)
+	writeTestLogs(t, wal, 3, 3)
+
+	filenames := testLogFiles(t, dir)
+	if len(filenames) < 2 || !strings.HasSuffix(filenames[len(filenames)-1], "_3.dat") {
+		t.Fatalf("files are %v, want log 3 in a new file", filenames)
+	}
+	checkTestLogs(t, wal, 1, 3)
+}
@@ -0,0 +1,207 @@
+package alfheimdbwal
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	//the stable store file in wal dir
+	STABLE_FILENAME = "STABLE"
+	//Magic 8Bytes + Count 4Bytes, the records, then CRC32C 4Bytes
+	STABLE_HEADER_LENGTH = 8 + 4
+	//Key Length 4Bytes + Value Length 4Bytes
+	STABLE_RECORD_HEADER_LENGTH = 4 + 4
+)
+
+var STABLE_MAGIC = []byte("AFDBSTB\n")
+
+//StableStore keeps small keys and values beside the logs, such as the term and the vote of raft.
+//The STABLE file of the wal dir is replaced on every Set by a synced temp file and rename,
+//it has the old values or the new values, never a part of them. The rename is synced by the sync policy:
+//SyncAlways and SyncGroupCommit sync it before Set returns, SyncInterval and SyncNever by the next wal sync.
+//The store shares the lock of the wal dir, and is closed with the wal.
+type StableStore struct {
+	WAL *AlfheimDBWAL
+}
+
+//the values of the stable store
+type stableValues struct {
+	//guarded by wal.Mutex, replaced on every Set
+	kv map[string][]byte
+	//the STABLE file is renamed, the dir is not synced yet, guarded by wal.writeMutex
+	dirty bool
+}
+
+//the stable store in the wal dir
+func (wal *AlfheimDBWAL) Stable() *StableStore {
+	return &StableStore{WAL: wal}
+}
+
+//Load the STABLE file of the wal dir, no file is an empty store
+func (wal *AlfheimDBWAL) LoadStable() error {
+	filename := filepath.Join(wal.Dirname, STABLE_FILENAME)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		wal.stable.kv = make(map[string][]byte)
+		return nil
+	}
+	if err != nil {
+		return NewIOError("read", filename, err)
+	}
+	kv, err := DecodeStable(filename, b)
+	if err != nil {
+		return err
+	}
+	wal.stable.kv = kv
+	return nil
+}
+
+//The STABLE file struct:
+//┌──────────────┬──────────────┬─────────────────┬───────────────┐
+//│ Magic 8Bytes │ Count 4Bytes │     Records     │ CRC32C 4Bytes │
+//└──────────────┴──────────────┴─────────────────┴───────────────┘
+//The record struct:
+//┌───────────────────┬─────────────────────┬─────┬───────┐
+//│ Key Length 4Bytes │ Value Length 4Bytes │ Key │ Value │
+//└───────────────────┴─────────────────────┴─────┴───────┘
+//The records are sorted by key, the CRC32C covers the bytes before it.
+func EncodeStable(kv map[string][]byte) []byte {
+	keys := make([]string, 0, len(kv))
+	size := STABLE_HEADER_LENGTH + 4
+	for key, value := range kv {
+		keys = append(keys, key)
+		size = size + STABLE_RECORD_HEADER_LENGTH + len(key) + len(value)
+	}
+	sort.Strings(keys)
+	buff := make([]byte, size)
+	copy(buff, STABLE_MAGIC)
+	WriteUint32ToBuff(buff[8:], uint32(len(keys)), true)
+	pos := STABLE_HEADER_LENGTH
+	for _, key := range keys {
+		value := kv[key]
+		WriteUint32ToBuff(buff[pos:], uint32(len(key)), true)
+		WriteUint32ToBuff(buff[pos+4:], uint32(len(value)), true)
+		pos = pos + STABLE_RECORD_HEADER_LENGTH
+		pos = pos + copy(buff[pos:], key)
+		pos = pos + copy(buff[pos:], value)
+	}
+	WriteUint32ToBuff(buff[pos:], crc32.Checksum(buff[:pos], Crc32cTable), true)
+	return buff
+}
+
+//Decode the STABLE file, see EncodeStable
+func DecodeStable(filename string, buff []byte) (map[string][]byte, error) {
+	if len(buff) < STABLE_HEADER_LENGTH+4 || !bytes.Equal(buff[:8], STABLE_MAGIC) {
+		return nil, NewCorruptError(filename, 0, "not a stable store file")
+	}
+	end := len(buff) - 4
+	if crc32.Checksum(buff[:end], Crc32cTable) != ReadUint32FromBuff(buff[end:], true) {
+		return nil, NewCorruptError(filename, int64(end), "stable store checksum mismatch")
+	}
+	count := int(ReadUint32FromBuff(buff[8:], true))
+	kv := make(map[string][]byte, count)
+	pos := STABLE_HEADER_LENGTH
+	for i := 0; i < count; i++ {
+		if end-pos < STABLE_RECORD_HEADER_LENGTH {
+			return nil, NewCorruptError(filename, int64(pos), fmt.Sprintf("record %d of %d is short", i, count))
+		}
+		keyLength := int(ReadUint32FromBuff(buff[pos:], true))
+		valueLength := int(ReadUint32FromBuff(buff[pos+4:], true))
+		pos = pos + STABLE_RECORD_HEADER_LENGTH
+		if end-pos < keyLength+valueLength {
+			return nil, NewCorruptError(filename, int64(pos), fmt.Sprintf("record %d of %d is short", i, count))
+		}
+		key := string(buff[pos : pos+keyLength])
+		pos = pos + keyLength
+		kv[key] = buff[pos : pos+valueLength]
+		pos = pos + valueLength
+	}
+	if pos != end {
+		return nil, NewCorruptError(filename, int64(pos), fmt.Sprintf("%d bytes after the records", end-pos))
+	}
+	return kv, nil
+}
+
+//Set key to value, the STABLE file is replaced, and synced by the sync policy
+func (s *StableStore) Set(key []byte, value []byte) error {
+	wal := s.WAL
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	kv := make(map[string][]byte, len(wal.stable.kv)+1)
+	for k, v := range wal.stable.kv {
+		kv[k] = v
+	}
+	kv[string(key)] = append([]byte(nil), value...)
+	err := ReplaceFile(filepath.Join(wal.Dirname, STABLE_FILENAME), EncodeStable(kv), wal.Options.FileMode)
+	if err != nil {
+		return err
+	}
+	wal.Mutex.Lock()
+	wal.stable.kv = kv
+	wal.Mutex.Unlock()
+	wal.stable.dirty = true
+	switch wal.Options.SyncPolicy {
+	case SyncAlways, SyncGroupCommit:
+		return wal.SyncStable()
+	}
+	return nil
+}
+
+//The value of key, ErrNotFound if the key is not set
+func (s *StableStore) Get(key []byte) ([]byte, error) {
+	wal := s.WAL
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		return nil, ErrClosed
+	}
+	value, ok := wal.stable.kv[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *StableStore) SetUint64(key []byte, value uint64) error {
+	buff := make([]byte, 8)
+	WriteInt64ToBuff(buff, int64(value), true)
+	return s.Set(key, buff)
+}
+
+//The uint64 value of key, ErrNotFound if the key is not set
+func (s *StableStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("value of key %q is %d bytes, not a uint64", key, len(value))
+	}
+	return ReadInt64FromBuff(value, true), nil
+}
+
+//fsync the wal dir if the STABLE file is renamed after last sync.
+//The caller holds wal.writeMutex.
+func (wal *AlfheimDBWAL) SyncStable() error {
+	if !wal.stable.dirty {
+		return nil
+	}
+	err := SyncDir(wal.Dirname)
+	if err != nil {
+		return err
+	}
+	wal.stable.dirty = false
+	return nil
+}
@@ -0,0 +1,61 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStableStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	wal := openTestWAL(t, dir, opts)
+	stable := wal.Stable()
+	_, err := stable.Get([]byte("vote"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get a key of an empty store returns %v, want %v", err, ErrNotFound)
+	}
+	err = stable.Set([]byte("vote"), []byte("node-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stable.SetUint64([]byte("term"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the last set wins, an empty value is a value
+	err = stable.SetUint64([]byte("term"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stable.Set([]byte("empty"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLogs(t, wal, 1, 3)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	stable = wal.Stable()
+	vote, err := stable.Get([]byte("vote"))
+	if err != nil || string(vote) != "node-1" {
+		t.Fatalf("vote is %q, %v, want %q", vote, err, "node-1")
+	}
+	term, err := stable.GetUint64([]byte("term"))
+	if err != nil || term != 5 {
+		t.Fatalf("term is %d, %v, want 5", term, err)
+	}
+	empty, err := stable.Get([]byte("empty"))
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("empty value is %q, %v", empty, err)
+	}
+	_, err = stable.Get([]byte("missing"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get a missing key returns %v, want %v", err, ErrNotFound)
+	}
+	_, err = stable.GetUint64([]byte("missing"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get a missing uint64 key returns %v, want %v", err, ErrNotFound)
+	}
+	checkTestLogs(t, wal, 1, 3)
+}
@@ -0,0 +1,219 @@
+package alfheimdbwal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//max bytes a subscription reads by one GetLogs
+const SUBSCRIPTION_READ_BYTES = 1 << 20
+
+//Subscription follows the wal from an index, Next blocks until the next log is durable.
+//The logs are read only when Next is called, a slow reader never makes the writers wait.
+//If TruncateBack, Overwrite, Reset or a TruncateLog of the tail removes logs the subscription returned,
+//Next fails with ErrOutOfRange, the logs after the truncation may be replaced by other logs.
+//
+//	sub := wal.Subscribe(ctx, from)
+//	defer sub.Close()
+//	for sub.Next() {
+//		replicate(sub.Index(), sub.Data())
+//	}
+//	if sub.Err() != nil {
+//	}
+type Subscription struct {
+	WAL *AlfheimDBWAL
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	//the next index to read, and the logs read but not returned, from next
+	next int64
+	logs [][]byte
+
+	index int64
+	data  []byte
+	err   error
+
+	//the lowest index a tail truncation keeps since the last check, -1 if none, guarded by wal.durable.mutex
+	retractIndex int64
+}
+
+//the logs durable up to durableIndex, notify is closed when it grows
+type durableNotifier struct {
+	mutex        sync.Mutex
+	durableIndex int64
+	notify       chan struct{}
+	//the wal is closed, notify is closed and never replaced
+	closed bool
+	//the subscriptions told about tail truncations
+	subs map[*Subscription]struct{}
+}
+
+//Follow the logs from fromIndex, cancel the ctx or call Close to stop it
+func (wal *AlfheimDBWAL) Subscribe(ctx context.Context, fromIndex int64) *Subscription {
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &Subscription{WAL: wal, ctx: ctx, cancel: cancel, next: fromIndex, index: -1, retractIndex: -1}
+	wal.durable.mutex.Lock()
+	if !wal.durable.closed {
+		wal.durable.subs[sub] = struct{}{}
+	}
+	wal.durable.mutex.Unlock()
+	return sub
+}
+
+//Wake the subscriptions waiting for the logs up to wal.MaxIndex.
+//The caller holds wal.writeMutex, and the logs are durable by the sync policy.
+func (wal *AlfheimDBWAL) PublishDurable() {
+	wal.durable.mutex.Lock()
+	defer wal.durable.mutex.Unlock()
+	if wal.durable.closed || wal.MaxIndex == wal.durable.durableIndex {
+		return
+	}
+	wal.durable.durableIndex = wal.MaxIndex
+	close(wal.durable.notify)
+	wal.durable.notify = make(chan struct{})
+}
+
+//Logs after index will be truncated, subscriptions must not read them any more,
+//the subscriptions returned them fail by the next Next.
+//The caller holds wal.writeMutex.
+func (wal *AlfheimDBWAL) RetractDurable(index int64) {
+	wal.durable.mutex.Lock()
+	defer wal.durable.mutex.Unlock()
+	if index < wal.durable.durableIndex {
+		wal.durable.durableIndex = index
+	}
+	for sub := range wal.durable.subs {
+		if sub.retractIndex == -1 || index < sub.retractIndex {
+			sub.retractIndex = index
+		}
+	}
+	if !wal.durable.closed && len(wal.durable.subs) != 0 {
+		close(wal.durable.notify)
+		wal.durable.notify = make(chan struct{})
+	}
+}
+
+//Wake all subscriptions, they return ErrClosed
+func (wal *AlfheimDBWAL) CloseDurable() {
+	wal.durable.mutex.Lock()
+	defer wal.durable.mutex.Unlock()
+	if wal.durable.closed {
+		return
+	}
+	wal.durable.closed = true
+	wal.durable.subs = nil
+	close(wal.durable.notify)
+}
+
+//the durable index, and the channel closed when it changes, ErrClosed if the wal is closed
+func (wal *AlfheimDBWAL) DurableIndex() (int64, <-chan struct{}, error) {
+	wal.durable.mutex.Lock()
+	defer wal.durable.mutex.Unlock()
+	if wal.durable.closed {
+		return 0, nil, ErrClosed
+	}
+	return wal.durable.durableIndex, wal.durable.notify, nil
+}
+
+//Move to the next log, block until it is durable.
+//false if the ctx is done, the subscription or the wal is closed, or an error happens.
+func (sub *Subscription) Next() bool {
+	if sub.err != nil {
+		return false
+	}
+	_, err := sub.checkRetracted()
+	if err != nil {
+		return sub.fail(err)
+	}
+	for len(sub.logs) == 0 {
+		durableIndex, notify, err := sub.WAL.DurableIndex()
+		if err != nil {
+			return sub.fail(err)
+		}
+		if durableIndex >= sub.next {
+			logs, err := sub.WAL.GetLogs(sub.next, durableIndex, SUBSCRIPTION_READ_BYTES)
+			if err != nil {
+				//the logs are truncated after the durable index is taken, read again
+				retracted, retractErr := sub.checkRetracted()
+				if retractErr != nil {
+					return sub.fail(retractErr)
+				}
+				if retracted {
+					continue
+				}
+				return sub.fail(err)
+			}
+			sub.logs = logs
+		} else {
+			select {
+			case <-notify:
+			case <-sub.ctx.Done():
+				return sub.fail(sub.ctx.Err())
+			}
+		}
+		//the logs read after a truncation may be not durable yet, read them again
+		_, err = sub.checkRetracted()
+		if err != nil {
+			return sub.fail(err)
+		}
+	}
+	sub.index = sub.next
+	sub.data = sub.logs[0]
+	sub.logs = sub.logs[1:]
+	sub.next++
+	return true
+}
+
+//A tail truncation since the last check removes the logs after its index, true if there is one.
+//ErrOutOfRange if the subscription returned any of them, or else the logs read but not returned are dropped.
+func (sub *Subscription) checkRetracted() (bool, error) {
+	durable := &sub.WAL.durable
+	durable.mutex.Lock()
+	index := sub.retractIndex
+	sub.retractIndex = -1
+	durable.mutex.Unlock()
+	if index == -1 {
+		return false, nil
+	}
+	if sub.index != -1 && index < sub.index {
+		return true, fmt.Errorf("%w: logs after %d are truncated, the subscription returned logs to %d", ErrOutOfRange, index, sub.index)
+	}
+	sub.logs = nil
+	return true, nil
+}
+
+//stop Next by err, the wal forgets the subscription
+func (sub *Subscription) fail(err error) bool {
+	sub.err = err
+	sub.unsubscribe()
+	return false
+}
+
+func (sub *Subscription) unsubscribe() {
+	sub.WAL.durable.mutex.Lock()
+	delete(sub.WAL.durable.subs, sub)
+	sub.WAL.durable.mutex.Unlock()
+}
+
+//index of the current log
+func (sub *Subscription) Index() int64 {
+	return sub.index
+}
+
+//data of the current log, it is not reused by Next
+func (sub *Subscription) Data() []byte {
+	return sub.data
+}
+
+//the error stopped Next, context.Canceled after Close
+func (sub *Subscription) Err() error {
+	return sub.err
+}
+
+//stop the subscription, a Next blocked in other goroutine returns false
+func (sub *Subscription) Close() error {
+	sub.cancel()
+	sub.unsubscribe()
+	return nil
+}
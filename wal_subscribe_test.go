@@ -0,0 +1,95 @@
+package alfheimdbwal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//the subscription returns the logs [from, to] in order
+func nextTestLogs(t *testing.T, sub *Subscription, from, to int64) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if !sub.Next() {
+			t.Fatalf("next stops before log %d: %v", i, sub.Err())
+		}
+		if sub.Index() != i || string(sub.Data()) != string(testData(i)) {
+			t.Fatalf("subscription returns log %d %q, want %d", sub.Index(), sub.Data(), i)
+		}
+	}
+}
+
+func TestSubscriptionFailsOnTruncatedReturnedLogs(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 10)
+	sub := wal.Subscribe(context.Background(), 1)
+	defer sub.Close()
+	nextTestLogs(t, sub, 1, 8)
+
+	err := wal.TruncateBack(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLogs(t, wal, 6, 12)
+	if sub.Next() {
+		t.Fatalf("next returns log %d after the returned logs are truncated", sub.Index())
+	}
+	if !errors.Is(sub.Err(), ErrOutOfRange) {
+		t.Fatalf("next fails by %v, want %v", sub.Err(), ErrOutOfRange)
+	}
+}
+
+func TestSubscriptionDropsTruncatedUnreturnedLogs(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := wal.Subscribe(ctx, 1)
+	defer sub.Close()
+	//logs 4-10 are read but not returned
+	nextTestLogs(t, sub, 1, 3)
+
+	err := wal.TruncateBack(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLogs(t, wal, 6, 7)
+	nextTestLogs(t, sub, 4, 7)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if sub.Next() {
+		t.Fatalf("next returns truncated log %d", sub.Index())
+	}
+	if !errors.Is(sub.Err(), context.Canceled) {
+		t.Fatalf("next fails by %v, want %v", sub.Err(), context.Canceled)
+	}
+}
+
+func TestSubscriptionWakesOnTruncate(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 10)
+	sub := wal.Subscribe(context.Background(), 1)
+	defer sub.Close()
+	nextTestLogs(t, sub, 1, 10)
+
+	done := make(chan bool)
+	go func() {
+		done <- sub.Next()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	err := wal.Reset(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ok := <-done:
+		if ok || !errors.Is(sub.Err(), ErrOutOfRange) {
+			t.Fatalf("next returns %v, %v, want %v", ok, sub.Err(), ErrOutOfRange)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("next is not woken by the reset")
+	}
+}
@@ -0,0 +1,97 @@
+package alfheimdbwal
+
+import (
+	"time"
+)
+
+type SyncPolicy int8
+
+const (
+	//fsync after every WriteLog and BatchWriteLog
+	SyncAlways SyncPolicy = 0
+	//fsync in background every Options.SyncInterval
+	SyncInterval SyncPolicy = 1
+	//never fsync, the os flushes the page cache, call wal.Sync if needed
+	SyncNever SyncPolicy = 2
+	//fsync like SyncAlways, concurrent writes are merged into one write and one fsync
+	SyncGroupCommit SyncPolicy = 3
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	case SyncGroupCommit:
+		return "group-commit"
+	}
+	return "unknow"
+}
+
+//fsync all written but not synced files, and the stable store
+func (wal *AlfheimDBWAL) Sync() error {
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	if wal.Options.ReadOnly {
+		return nil
+	}
+	return wal.SyncFiles()
+}
+
+//fsync every dirty file. The files are written in order, but a rewrite makes a file clean
+//whatever the files before it are, so no file is skipped.
+//The caller holds wal.writeMutex, the files are not changed while syncing.
+func (wal *AlfheimDBWAL) SyncFiles() error {
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		err := elem.Value.(*AlfheimDBWALFile).Sync()
+		if err != nil {
+			return err
+		}
+	}
+	wal.PublishDurable()
+	return wal.SyncStable()
+}
+
+//fsync the written files if the sync policy is SyncAlways, or SyncGroupCommit for a write out of the group.
+//With SyncNever the os makes the logs durable, they are published at once,
+//with SyncInterval they are published by the next sync.
+func (wal *AlfheimDBWAL) SyncAfterWrite() error {
+	switch wal.Options.SyncPolicy {
+	case SyncAlways, SyncGroupCommit:
+		return wal.SyncFiles()
+	case SyncNever:
+		wal.PublishDurable()
+	}
+	return nil
+}
+
+//background fsync for SyncInterval
+func (wal *AlfheimDBWAL) StartSyncLoop() {
+	if wal.Options.SyncPolicy != SyncInterval {
+		return
+	}
+	wal.syncStop = make(chan struct{})
+	wal.wg.Add(1)
+	go func() {
+		defer wal.wg.Done()
+		ticker := time.NewTicker(wal.Options.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := wal.Sync()
+				if err != nil {
+					wal.Logger.Error("Background sync error, ", err)
+				}
+			case <-wal.syncStop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package alfheimdbwal
+
+import (
+	"testing"
+	"time"
+)
+
+//files written but not synced
+func dirtyTestFiles(wal *AlfheimDBWAL) int {
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	count := 0
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(*AlfheimDBWALFile).Dirty {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSyncAlways(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), testOptions())
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 3)
+	batchTestLogs(t, wal, 4, 6)
+	if n := dirtyTestFiles(wal); n != 0 {
+		t.Fatalf("%d files are not synced after write", n)
+	}
+}
+
+func TestSyncNever(t *testing.T) {
+	opts := testOptions()
+	opts.SyncPolicy = SyncNever
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 3)
+	if n := dirtyTestFiles(wal); n != 1 {
+		t.Fatalf("%d files are not synced after write, want 1", n)
+	}
+	err := wal.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := dirtyTestFiles(wal); n != 0 {
+		t.Fatalf("%d files are not synced after sync", n)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	opts := testOptions()
+	opts.SyncPolicy = SyncInterval
+	opts.SyncInterval = 10 * time.Millisecond
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for dirtyTestFiles(wal) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the files are not synced in background")
+		}
+		time.Sleep(opts.SyncInterval)
+	}
+	checkTestLogs(t, wal, 1, 3)
+}
+
+func TestSyncSealedFileOnRotation(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	opts.SyncPolicy = SyncNever
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 12)
+	//only the last file is written after its last sync
+	if n := dirtyTestFiles(wal); n != 1 {
+		t.Fatalf("%d files are not synced, want 1", n)
+	}
+}
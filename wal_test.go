@@ -0,0 +1,412 @@
+package alfheimdbwal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+//options of the tests, logs only errors
+func testOptions() Options {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	opts := DefaultOptions()
+	opts.Logger = logger
+	opts.CompactInterval = 0
+	return opts
+}
+
+func testData(index int64) []byte {
+	return []byte(fmt.Sprintf("log-%d", index))
+}
+
+//the logs [from, to] and their frames
+func testLogs(from, to int64) ([]*LogItem, []byte) {
+	size := 0
+	for i := from; i <= to; i++ {
+		size = size + LOG_ITEM_HEADER_LENGTH + len(testData(i))
+	}
+	buff := make([]byte, size)
+	lItems := make([]*LogItem, 0, to-from+1)
+	pos := 0
+	for i := from; i <= to; i++ {
+		data := testData(i)
+		lItems = append(lItems, NewLogItemBuff(i, data, buff[pos:], true))
+		pos = pos + LOG_ITEM_HEADER_LENGTH + len(data)
+	}
+	return lItems, buff
+}
+
+func openTestWAL(t *testing.T, dir string, opts Options) *AlfheimDBWAL {
+	t.Helper()
+	wal, err := NewWALWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	return wal
+}
+
+func closeTestWAL(t *testing.T, wal *AlfheimDBWAL) {
+	t.Helper()
+	err := wal.Close()
+	if err != nil {
+		t.Fatalf("close wal: %v", err)
+	}
+}
+
+//write the logs [from, to] one by one
+func writeTestLogs(t *testing.T, wal *AlfheimDBWAL, from, to int64) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		lItems, data := testLogs(i, i)
+		err := wal.WriteLog(lItems[0], data)
+		if err != nil {
+			t.Fatalf("write log %d: %v", i, err)
+		}
+	}
+}
+
+//write the logs [from, to] in one batch
+func batchTestLogs(t *testing.T, wal *AlfheimDBWAL, from, to int64) {
+	t.Helper()
+	lItems, data := testLogs(from, to)
+	err := wal.BatchWriteLog(lItems, data)
+	if err != nil {
+		t.Fatalf("batch write logs [%d, %d]: %v", from, to, err)
+	}
+}
+
+//the wal has the logs [first, last], and only them
+func checkTestLogs(t *testing.T, wal *AlfheimDBWAL, first, last int64) {
+	t.Helper()
+	index, err := wal.FirstIndex()
+	if err != nil || index != first {
+		t.Fatalf("first index is %d, %v, want %d", index, err, first)
+	}
+	index, err = wal.LastIndex()
+	if err != nil || index != last {
+		t.Fatalf("last index is %d, %v, want %d", index, err, last)
+	}
+	for i := first; i <= last; i++ {
+		data, err := wal.GetLog(i)
+		if err != nil {
+			t.Fatalf("get log %d: %v", i, err)
+		}
+		if string(data) != string(testData(i)) {
+			t.Fatalf("log %d is %q, want %q", i, data, testData(i))
+		}
+	}
+	for _, i := range []int64{first - 1, last + 1} {
+		_, err := wal.GetLog(i)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get log %d returns %v, want %v", i, err, ErrNotFound)
+		}
+	}
+}
+
+//the log files of dir, ordered by their first index
+func testLogFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	filenames, err := filepath.Glob(filepath.Join(dir, "log_*.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := func(filename string) int64 {
+		var unix, index int64
+		fmt.Sscanf(filepath.Base(filename), "log_%d_%d.dat", &unix, &index)
+		return index
+	}
+	sort.Slice(filenames, func(i, j int) bool { return first(filenames[i]) < first(filenames[j]) })
+	return filenames
+}
+
+//cut n bytes from the end of the file, as a write torn by a crash
+func chopTestFile(t *testing.T, filename string, n int64) {
+	t.Helper()
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(filename, info.Size()-n)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//the file and the frame pos of log index
+func testLogFrame(t *testing.T, wal *AlfheimDBWAL, index int64) (string, int64) {
+	t.Helper()
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	elem := wal.FindFileElem(index)
+	if elem == nil {
+		t.Fatalf("log %d has no file", index)
+	}
+	aFile := elem.Value.(*AlfheimDBWALFile)
+	lItem, ok := aFile.LogItems[index]
+	if !ok {
+		t.Fatalf("log %d is not in %s", index, aFile.Filename)
+	}
+	return aFile.Filename, int64(lItem.Pos) - aFile.ItemHeaderLength()
+}
+
+//the file of log index in wal
+func testLogFile(t *testing.T, wal *AlfheimDBWAL, index int64) *AlfheimDBWALFile {
+	t.Helper()
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	elem := wal.FindFileElem(index)
+	if elem == nil {
+		t.Fatalf("log %d has no file", index)
+	}
+	return elem.Value.(*AlfheimDBWALFile)
+}
+
+//xor the byte at pos of the file with mask, as bits flipped on disk
+func flipTestFile(t *testing.T, filename string, pos int64, mask byte) {
+	t.Helper()
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] = b[0] ^ mask
+	_, err = f.WriteAt(b, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetLogsByteBudget(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxItems = 10
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 30)
+
+	//the data of the logs [1, 9] is 5 bytes each, of the logs after is 6 bytes each
+	cases := []struct {
+		start, end int64
+		maxBytes   int64
+		last       int64
+	}{
+		{1, 30, 0, 30},
+		{1, 30, -1, 30},
+		{1, 20, 0, 20},
+		{1, 30, 15, 3},
+		{1, 30, 14, 2},
+		//one log is returned whatever the budget
+		{1, 30, 1, 1},
+		{10, 30, 5, 10},
+		//the budget is used across files, the first file has the logs [1, 10]
+		{1, 30, 56, 10},
+		{1, 30, 57, 11},
+		{5, 30, 200, 30},
+	}
+	for _, c := range cases {
+		logs, err := wal.GetLogs(c.start, c.end, c.maxBytes)
+		if err != nil {
+			t.Fatalf("get logs [%d, %d] max bytes %d: %v", c.start, c.end, c.maxBytes, err)
+		}
+		if int64(len(logs)) != c.last-c.start+1 {
+			t.Fatalf("get logs [%d, %d] max bytes %d returns %d logs, want [%d, %d]", c.start, c.end, c.maxBytes, len(logs), c.start, c.last)
+		}
+		for i, data := range logs {
+			if string(data) != string(testData(c.start+int64(i))) {
+				t.Fatalf("log %d is %q, want %q", c.start+int64(i), data, testData(c.start+int64(i)))
+			}
+		}
+	}
+
+	//the logs stop at the first missing index
+	err := wal.TruncateLog(15, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := wal.GetLogs(12, 20, 0)
+	if err != nil || len(logs) != 3 {
+		t.Fatalf("get logs [12, 20] with log 15 truncated returns %d logs, %v, want [12, 14]", len(logs), err)
+	}
+	for _, start := range []int64{0, 15, 31} {
+		_, err = wal.GetLogs(start, 30, 0)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get logs from %d returns %v, want %v", start, err, ErrNotFound)
+		}
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	opts := testOptions()
+	opts.SegmentMaxItems = 50
+	opts.SyncPolicy = SyncAlways
+	wal := openTestWAL(t, t.TempDir(), opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 100)
+
+	//the readers read the logs [1, last index] while the writer appends and rotates files
+	const readers = 8
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for round := int64(0); ; round++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				last, err := wal.LastIndex()
+				if err != nil {
+					t.Errorf("last index: %v", err)
+					return
+				}
+				index := (round*readers+int64(r))%last + 1
+				data, err := wal.GetLog(index)
+				if err != nil || string(data) != string(testData(index)) {
+					t.Errorf("get log %d returns %q, %v", index, data, err)
+					return
+				}
+				logs, err := wal.GetLogs(index, last, 0)
+				if err != nil || int64(len(logs)) != last-index+1 {
+					t.Errorf("get logs [%d, %d] returns %d logs, %v", index, last, len(logs), err)
+					return
+				}
+				for i, data := range logs {
+					if string(data) != string(testData(index+int64(i))) {
+						t.Errorf("log %d is %q, want %q", index+int64(i), data, testData(index+int64(i)))
+						return
+					}
+				}
+			}
+		}(r)
+	}
+	writeTestLogs(t, wal, 101, 600)
+	close(done)
+	wg.Wait()
+	checkTestLogs(t, wal, 1, 600)
+}
+
+func TestClosedWAL(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SyncPolicy = SyncGroupCommit
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 10)
+	it := wal.NewIterator(1, 10)
+	defer it.Close()
+	closeTestWAL(t, wal)
+
+	lItem, lItemData := testLogs(11, 11)
+	lItems, data := testLogs(11, 12)
+	calls := map[string]func() error{
+		"get log":         func() error { _, err := wal.GetLog(1); return err },
+		"get logs":        func() error { _, err := wal.GetLogs(1, 10, 0); return err },
+		"write log":       func() error { return wal.WriteLog(lItem[0], lItemData) },
+		"batch write log": func() error { return wal.BatchWriteLog(lItems, data) },
+		"sync":            wal.Sync,
+		"truncate log":    func() error { return wal.TruncateLog(1, 1) },
+		"truncate front":  func() error { return wal.TruncateFront(2) },
+		"truncate back":   func() error { return wal.TruncateBack(9) },
+		"first index":     func() error { _, err := wal.FirstIndex(); return err },
+		"last index":      func() error { _, err := wal.LastIndex(); return err },
+		"compact":         wal.Compact,
+		"reset":           func() error { return wal.Reset(100) },
+		"iterator":        func() error { it.Next(); return it.Err() },
+		"close":           wal.Close,
+	}
+	for name, call := range calls {
+		err := call()
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("%s after close returns %v, want %v", name, err, ErrClosed)
+		}
+	}
+
+	//the files and the dir lock are released
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 1, 10)
+}
+
+//the names and the data of the files in dir
+func testDirFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirFiles := make(map[string]string)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirFiles[file.Name()] = string(data)
+	}
+	return dirFiles
+}
+
+func TestOpenReadOnlyWithLiveWriter(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SegmentMaxItems = 5
+	wal := openTestWAL(t, dir, opts)
+	defer wal.Close()
+	writeTestLogs(t, wal, 1, 12)
+	before := testDirFiles(t, dir)
+
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatalf("open read only next to a writer: %v", err)
+	}
+	checkTestLogs(t, reader, 1, 12)
+	lItems, data := testLogs(13, 13)
+	writes := map[string]func() error{
+		"write log":      func() error { return reader.WriteLog(lItems[0], data) },
+		"truncate log":   func() error { return reader.TruncateLog(1, 1) },
+		"truncate front": func() error { return reader.TruncateFront(2) },
+		"truncate back":  func() error { return reader.TruncateBack(11) },
+		"reset":          func() error { return reader.Reset(100) },
+	}
+	for name, write := range writes {
+		err = write()
+		if !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("%s of a read only wal returns %v, want %v", name, err, ErrReadOnly)
+		}
+	}
+	closeTestWAL(t, reader)
+
+	//the read only wal never changes the dir
+	after := testDirFiles(t, dir)
+	if len(after) != len(before) {
+		t.Fatalf("the dir has %d files after a read only wal, want %d", len(after), len(before))
+	}
+	for name, data := range before {
+		if after[name] != data {
+			t.Fatalf("%s is changed by a read only wal", name)
+		}
+	}
+
+	//the logs written after it is opened are not read
+	reader, err = OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writeTestLogs(t, wal, 13, 15)
+	checkTestLogs(t, reader, 1, 12)
+	checkTestLogs(t, wal, 1, 15)
+}
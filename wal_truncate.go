@@ -0,0 +1,367 @@
+package alfheimdbwal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//index of the first log. If the wal is empty, it is the next index set by the last Reset,
+//or 0 if the wal never has a log or a TruncateLog removes all logs.
+func (wal *AlfheimDBWAL) FirstIndex() (int64, error) {
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		return 0, ErrClosed
+	}
+	if wal.FileIndex.Len() == 0 {
+		return wal.Meta.NextIndex, nil
+	}
+	return wal.MinIndex, nil
+}
+
+//index of the last log. If the wal is empty, it is the next index minus 1, see FirstIndex, or 0.
+func (wal *AlfheimDBWAL) LastIndex() (int64, error) {
+	wal.Mutex.RLock()
+	defer wal.Mutex.RUnlock()
+	if wal.closed {
+		return 0, ErrClosed
+	}
+	if wal.FileIndex.Len() == 0 {
+		if wal.Meta.NextIndex == 0 {
+			return 0, nil
+		}
+		return wal.Meta.NextIndex - 1, nil
+	}
+	return wal.MaxIndex, nil
+}
+
+//Remove the logs before index, such as the logs in a snapshot, index is the first log then.
+//The files before the file of index are removed, the logs before index in it are truncated by
+//a truncate area in its header, no log is read, it is O(number of files).
+//ErrOutOfRange if index is not in [FirstIndex, LastIndex].
+func (wal *AlfheimDBWAL) TruncateFront(index int64) error {
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	wal.Mutex.Lock()
+	defer wal.Mutex.Unlock()
+	if wal.FileIndex.Len() == 0 || index < wal.MinIndex || index > wal.MaxIndex {
+		return fmt.Errorf("%w: truncate front %d, the logs are [%d, %d]", ErrOutOfRange, index, wal.MinIndex, wal.MaxIndex)
+	}
+	if index == wal.MinIndex {
+		return nil
+	}
+
+	//remove the files from the front, a crash in the middle leaves the logs contiguous
+	var err error
+	removed := false
+	for elem := wal.FileIndex.Front(); elem != nil; elem = wal.FileIndex.Front() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		if aFile.MaxIndex >= index {
+			err = aFile.TruncateFront(index)
+			break
+		}
+		wal.Logger.Info("Truncate front, remove file: ", aFile.Filename)
+		wal.FileIndex.Remove(elem.Key())
+		delete(wal.AFiles, elem.Key().(int64))
+		removed = true
+		err = wal.RemoveFile(aFile)
+		if err != nil {
+			break
+		}
+	}
+	wal.RefreshAllMinAndMaxIndex()
+	if err != nil || !removed {
+		return err
+	}
+	return SyncDir(wal.Dirname)
+}
+
+//Remove the logs after index, such as the logs conflict with the leader, index is the last log then.
+//The files after the file of index are removed, the file of index is cut after index, it is O(number of files).
+//ErrOutOfRange if index is not in [FirstIndex, LastIndex].
+func (wal *AlfheimDBWAL) TruncateBack(index int64) error {
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	wal.Mutex.Lock()
+	defer wal.Mutex.Unlock()
+	return wal.TruncateBackFiles(index)
+}
+
+//TruncateBack, the caller holds wal.writeMutex and wal.Mutex
+func (wal *AlfheimDBWAL) TruncateBackFiles(index int64) error {
+	if wal.FileIndex.Len() == 0 || index < wal.MinIndex || index > wal.MaxIndex {
+		return fmt.Errorf("%w: truncate back %d, the logs are [%d, %d]", ErrOutOfRange, index, wal.MinIndex, wal.MaxIndex)
+	}
+	if index == wal.MaxIndex {
+		return nil
+	}
+	//subscriptions stop before the truncated logs
+	wal.RetractDurable(index)
+
+	last := wal.FindFileElem(index)
+	for last.Value.(*AlfheimDBWALFile).MinIndex > index {
+		last = last.Prev()
+	}
+	aFile := last.Value.(*AlfheimDBWALFile)
+	//the logs before the cut are committed, even if their batch is cut, or continued in the removed files
+	cutPos, err := aFile.CommitBefore(index)
+	if err != nil {
+		return err
+	}
+
+	//remove the files from the back, a crash in the middle leaves the logs contiguous
+	removed := false
+	for elem := wal.FileIndex.Back(); elem != last; elem = wal.FileIndex.Back() {
+		removedFile := elem.Value.(*AlfheimDBWALFile)
+		wal.Logger.Info("Truncate back, remove file: ", removedFile.Filename)
+		wal.FileIndex.Remove(elem.Key())
+		delete(wal.AFiles, elem.Key().(int64))
+		removed = true
+		err = wal.RemoveFile(removedFile)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = aFile.TruncateBack(index, cutPos)
+	}
+	wal.RefreshAllMinAndMaxIndex()
+	if err != nil || !removed {
+		return err
+	}
+	return SyncDir(wal.Dirname)
+}
+
+//Truncate the logs before index by one truncate area from the header start, the logs are not read.
+//The file is rewritten if the header overflows.
+func (aFile *AlfheimDBWALFile) TruncateFront(index int64) error {
+	elem := aFile.LogIndex.Find(index)
+	if elem == nil || elem.Prev() == nil {
+		return nil
+	}
+	aFile.MarkTruncated()
+	lItem := elem.Prev().Value.(*LogItem)
+	areas := aFile.Header.TruncateArea
+	aFile.Header.TruncateArea = MergeFrontArea(areas, aFile.HeaderLength, int64(lItem.Pos)+int64(lItem.Length))
+	rewritten, err := aFile.SaveHeaderOrRewrite(func() {
+		aFile.Header.TruncateArea = areas
+	}, func(lItem *LogItem) bool {
+		return lItem.Index >= index
+	})
+	if err != nil || rewritten {
+		return err
+	}
+	for elem = aFile.LogIndex.Front(); elem != nil && elem.Key().(int64) < index; elem = aFile.LogIndex.Front() {
+		aFile.LogIndex.Remove(elem.Key())
+		delete(aFile.LogItems, elem.Key().(int64))
+	}
+	aFile.MinIndex = aFile.LogIndex.Front().Key().(int64)
+	return nil
+}
+
+//the area [start, end) merged with the areas overlapped with it, the other areas are kept
+func MergeFrontArea(areas []*TruncateArea, start, end int64) []*TruncateArea {
+	sorted := make([]*TruncateArea, len(areas))
+	copy(sorted, areas)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	front := &TruncateArea{Start: start, End: end}
+	merged := []*TruncateArea{front}
+	for _, area := range sorted {
+		if area.Start > front.End {
+			merged = append(merged, area)
+			continue
+		}
+		if area.End > front.End {
+			front.End = area.End
+		}
+	}
+	return merged
+}
+
+//Mark the logs up to index committed before the logs after index are removed,
+//the pos to cut the file at is returned, the file pos if no log after index is in the file.
+func (aFile *AlfheimDBWALFile) CommitBefore(index int64) (int64, error) {
+	elem := aFile.LogIndex.Find(index + 1)
+	if elem == nil {
+		return aFile.Pos, aFile.SealContinuedBatch()
+	}
+	cutPos := int64(elem.Value.(*LogItem).Pos) - aFile.ItemHeaderLength()
+	if aFile.Version() < LOG_ITEM_VERSION_3 {
+		return cutPos, nil
+	}
+	rewritten, err := aFile.SaveCommitPos(cutPos, func(lItem *LogItem) bool {
+		return lItem.Index <= index
+	})
+	if rewritten {
+		return aFile.Pos, err
+	}
+	return cutPos, err
+}
+
+//Cut the file at cutPos from CommitBefore, the logs after index are removed from the index, not read.
+func (aFile *AlfheimDBWALFile) TruncateBack(index int64, cutPos int64) error {
+	if cutPos == aFile.Pos {
+		return nil
+	}
+	aFile.MarkTruncated()
+	err := aFile.File.Truncate(cutPos)
+	if err != nil {
+		return NewIOError("truncate", aFile.Filename, err)
+	}
+	err = SyncFile(*aFile.File)
+	if err != nil {
+		return err
+	}
+	aFile.Pos = cutPos
+	aFile.ContinuedPos = -1
+	for elem := aFile.LogIndex.Back(); elem != nil && elem.Key().(int64) > index; elem = aFile.LogIndex.Back() {
+		aFile.LogIndex.Remove(elem.Key())
+		delete(aFile.LogItems, elem.Key().(int64))
+	}
+	aFile.MaxIndex = aFile.LogIndex.Back().Key().(int64)
+	//the truncate areas after the cut would hide the logs appended there
+	return aFile.ClipTruncateAreas()
+}
+
+//Drop the parts of the truncate areas after the file pos, they are left by a cut of the file tail.
+func (aFile *AlfheimDBWALFile) ClipTruncateAreas() error {
+	areas := make([]*TruncateArea, 0, len(aFile.Header.TruncateArea))
+	clipped := false
+	for _, area := range aFile.Header.TruncateArea {
+		if area.End <= aFile.Pos {
+			areas = append(areas, area)
+			continue
+		}
+		clipped = true
+		if area.Start < aFile.Pos {
+			areas = append(areas, &TruncateArea{Start: area.Start, End: aFile.Pos})
+		}
+	}
+	if !clipped {
+		return nil
+	}
+	old := aFile.Header.TruncateArea
+	aFile.Header.TruncateArea = areas
+	_, err := aFile.SaveHeaderOrRewrite(func() {
+		aFile.Header.TruncateArea = old
+	}, nil)
+	return err
+}
+
+//Remove all logs, the next log appended is nextIndex, such as after a snapshot at nextIndex-1 is installed.
+//FirstIndex is nextIndex and LastIndex is nextIndex-1 until a log is appended.
+//The next index and the files to remove are saved in META before the files are removed,
+//if it crashes in the middle, the files are removed when the wal is opened.
+func (wal *AlfheimDBWAL) Reset(nextIndex int64) error {
+	if wal.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	if nextIndex < 1 {
+		return fmt.Errorf("%w: reset to next index %d, it must be positive", ErrOutOfRange, nextIndex)
+	}
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+	if wal.closed {
+		return ErrClosed
+	}
+	wal.Mutex.Lock()
+	defer wal.Mutex.Unlock()
+	return wal.ResetFiles(nextIndex)
+}
+
+//Reset, the caller holds wal.writeMutex and wal.Mutex
+func (wal *AlfheimDBWAL) ResetFiles(nextIndex int64) error {
+	//subscriptions stop before the removed logs, all logs are removed even if nextIndex is after them
+	retractIndex := nextIndex - 1
+	if wal.FileIndex.Len() != 0 && wal.MinIndex-1 < retractIndex {
+		retractIndex = wal.MinIndex - 1
+	}
+	wal.RetractDurable(retractIndex)
+
+	meta := *wal.Meta
+	meta.NextIndex = nextIndex
+	meta.RemoveFiles = make([]string, 0, wal.FileIndex.Len())
+	for elem := wal.FileIndex.Front(); elem != nil; elem = elem.Next() {
+		meta.RemoveFiles = append(meta.RemoveFiles, filepath.Base(elem.Value.(*AlfheimDBWALFile).Filename))
+	}
+	saved := wal.Meta
+	wal.Meta = &meta
+	err := wal.SaveMeta()
+	if err != nil {
+		wal.Meta = saved
+		return err
+	}
+
+	for elem := wal.FileIndex.Front(); elem != nil; elem = wal.FileIndex.Front() {
+		aFile := elem.Value.(*AlfheimDBWALFile)
+		wal.Logger.Info("Reset, remove file: ", aFile.Filename)
+		wal.FileIndex.Remove(elem.Key())
+		delete(wal.AFiles, elem.Key().(int64))
+		removeErr := wal.RemoveFile(aFile)
+		if err == nil {
+			err = removeErr
+		}
+	}
+	wal.RefreshAllMinAndMaxIndex()
+	if err != nil {
+		return err
+	}
+	err = SyncDir(wal.Dirname)
+	if err != nil {
+		return err
+	}
+	meta.RemoveFiles = nil
+	return wal.SaveMeta()
+}
+
+//Remove the files of a Reset crashed in the middle, the log files left are returned.
+//A read only wal skips the files, and never removes them.
+func (wal *AlfheimDBWAL) FinishReset(logFiles []string) ([]string, error) {
+	if len(wal.Meta.RemoveFiles) == 0 {
+		return logFiles, nil
+	}
+	removeFiles := make(map[string]bool, len(wal.Meta.RemoveFiles))
+	for _, name := range wal.Meta.RemoveFiles {
+		removeFiles[name] = true
+	}
+	left := make([]string, 0, len(logFiles))
+	for _, filename := range logFiles {
+		if !removeFiles[filepath.Base(filename)] {
+			left = append(left, filename)
+			continue
+		}
+		if wal.Options.ReadOnly {
+			continue
+		}
+		wal.Logger.Info("Finish reset, remove file: ", filename)
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, NewIOError("remove", filename, err)
+		}
+	}
+	if wal.Options.ReadOnly {
+		return left, nil
+	}
+	err := SyncDir(wal.Dirname)
+	if err != nil {
+		return nil, err
+	}
+	wal.Meta.RemoveFiles = nil
+	return left, wal.SaveMeta()
+}
@@ -0,0 +1,175 @@
+package alfheimdbwal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+//the truncate areas of the file of index leave no room for a commit pos in the header
+func commitPosOverflows(wal *AlfheimDBWAL, index int64) bool {
+	aFile := wal.FindFileElem(index).Value.(*AlfheimDBWALFile)
+	header := *aFile.Header
+	header.CommitPos = aFile.Pos
+	b, _ := json.Marshal(&header)
+	return int64(HEADER_SLOT_PREFIX_LENGTH+len(b)) > aFile.HeaderSlotLength()
+}
+
+//truncate single logs until the areas of the file of index fill the header, the truncated logs are returned.
+//A full header is rewritten on the way.
+func fillTestHeader(t *testing.T, wal *AlfheimDBWAL, index int64) map[int64]bool {
+	t.Helper()
+	truncated := make(map[int64]bool)
+	for i := int64(2); !commitPosOverflows(wal, index); i = i + 2 {
+		if i >= index {
+			t.Fatal("the truncate areas never fill the header")
+		}
+		err := wal.TruncateLog(i, i)
+		if err != nil {
+			t.Fatalf("truncate log %d: %v", i, err)
+		}
+		truncated[i] = true
+	}
+	return truncated
+}
+
+//the wal has the logs [1, last] but the truncated ones
+func checkTruncatedTestLogs(t *testing.T, wal *AlfheimDBWAL, last int64, truncated map[int64]bool) {
+	t.Helper()
+	index, err := wal.LastIndex()
+	if err != nil || index != last {
+		t.Fatalf("last index is %d, %v, want %d", index, err, last)
+	}
+	for i := int64(1); i <= last+1; i++ {
+		data, err := wal.GetLog(i)
+		if truncated[i] || i == last+1 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("get truncated log %d returns %v, want %v", i, err, ErrNotFound)
+			}
+			continue
+		}
+		if err != nil || string(data) != string(testData(i)) {
+			t.Fatalf("log %d is %q, %v, want %q", i, data, err, testData(i))
+		}
+	}
+}
+
+func TestTruncateBackWithFullHeader(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.HeaderLength = MIN_HEADER_LENGTH
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 200)
+	truncated := fillTestHeader(t, wal, 161)
+
+	err := wal.TruncateBack(161)
+	if err != nil {
+		t.Fatalf("truncate back with a full header: %v", err)
+	}
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTruncatedTestLogs(t, wal, 161, truncated)
+	writeTestLogs(t, wal, 162, 170)
+}
+
+func TestTruncateTailWithFullHeader(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.HeaderLength = MIN_HEADER_LENGTH
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 200)
+	truncated := fillTestHeader(t, wal, 161)
+
+	err := wal.TruncateLog(162, 200)
+	if err != nil {
+		t.Fatalf("truncate the tail with a full header: %v", err)
+	}
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTruncatedTestLogs(t, wal, 161, truncated)
+}
+
+//the wal is empty, FirstIndex and LastIndex are first and last
+func checkEmptyTestWAL(t *testing.T, wal *AlfheimDBWAL, first, last int64) {
+	t.Helper()
+	index, err := wal.FirstIndex()
+	if err != nil || index != first {
+		t.Fatalf("first index is %d, %v, want %d", index, err, first)
+	}
+	index, err = wal.LastIndex()
+	if err != nil || index != last {
+		t.Fatalf("last index is %d, %v, want %d", index, err, last)
+	}
+	_, err = wal.GetLog(first)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get log %d of an empty wal returns %v, want %v", first, err, ErrNotFound)
+	}
+}
+
+func TestTruncateAllClearsNextIndex(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.StrictIndex = true
+	wal := openTestWAL(t, dir, opts)
+	err := wal.Reset(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLogs(t, wal, 100, 110)
+	err = wal.TruncateLog(100, 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the wal never goes back to the reset point 100
+	checkEmptyTestWAL(t, wal, 0, 0)
+	closeTestWAL(t, wal)
+
+	//the logs after a snapshot start at any index
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkEmptyTestWAL(t, wal, 0, 0)
+	writeTestLogs(t, wal, 201, 202)
+	checkTestLogs(t, wal, 201, 202)
+	lItems, data := testLogs(204, 204)
+	err = wal.BatchWriteLog(lItems, data)
+	if !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("write log 204 returns %v, want %v", err, ErrOutOfOrder)
+	}
+}
+
+func TestResetReopen(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.StrictIndex = true
+	opts.SegmentMaxItems = 5
+	wal := openTestWAL(t, dir, opts)
+	writeTestLogs(t, wal, 1, 12)
+	err := wal.Reset(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEmptyTestWAL(t, wal, 100, 99)
+	if files := testLogFiles(t, dir); len(files) != 0 {
+		t.Fatalf("%d log files are left by reset", len(files))
+	}
+	closeTestWAL(t, wal)
+
+	//the next index is kept in META
+	wal = openTestWAL(t, dir, opts)
+	checkEmptyTestWAL(t, wal, 100, 99)
+	lItems, data := testLogs(13, 13)
+	err = wal.BatchWriteLog(lItems, data)
+	if !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("write log 13 after reset returns %v, want %v", err, ErrOutOfOrder)
+	}
+	writeTestLogs(t, wal, 100, 107)
+	closeTestWAL(t, wal)
+
+	wal = openTestWAL(t, dir, opts)
+	defer wal.Close()
+	checkTestLogs(t, wal, 100, 107)
+}
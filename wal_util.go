@@ -9,23 +9,51 @@
 
 package alfheimdbwal
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
 
+//The log item struct:
+//┌───────────────┬──────────────┬────────────────┬─────────────────┐
+//│ Length 8Bytes │ Index 8Bytes │ CRC32C 4Bytes  │      Data       │
+//└───────────────┴──────────────┴────────────────┴─────────────────┘
+//buff must have LOG_ITEM_HEADER_LENGTH + len(data) bytes
 func NewLogItemBuff(index int64, data []byte, buff []byte, isBigEndian bool) *LogItem {
 	length := len(data)
 	WriteInt64ToBuff(buff, int64(length), isBigEndian)
 	WriteInt64ToBuff(buff[8:], index, isBigEndian)
-	copy(buff[16:], data)
+	WriteUint32ToBuff(buff[16:], LogItemChecksum(buff[:16], data), isBigEndian)
+	copy(buff[LOG_ITEM_HEADER_LENGTH:], data)
 	lItem := new(LogItem)
 	lItem.Index = index
 	lItem.Length = uint64(length)
 	return lItem
 }
 
+//control item index is the first log index of the batch part, count is the logs of the part
+//buff must have CONTROL_ITEM_LENGTH bytes
+func NewControlItemBuff(ctype ControlType, index int64, count int, buff []byte, isBigEndian bool) {
+	data := buff[LOG_ITEM_HEADER_LENGTH:CONTROL_ITEM_LENGTH]
+	data[0] = byte(ctype)
+	WriteInt64ToBuff(data[1:], int64(count), isBigEndian)
+	length := uint64(CONTROL_ITEM_DATA_LENGTH) | LOG_ITEM_CONTROL_FLAG
+	WriteInt64ToBuff(buff, int64(length), isBigEndian)
+	WriteInt64ToBuff(buff[8:], index, isBigEndian)
+	WriteUint32ToBuff(buff[16:], LogItemChecksum(buff[:16], data), isBigEndian)
+}
+
+func ParseControlItem(data []byte, isBigEndian bool) (ControlType, int) {
+	if len(data) != CONTROL_ITEM_DATA_LENGTH {
+		return 0, 0
+	}
+	return ControlType(data[0]), int(ReadInt64FromBuff(data[1:], isBigEndian))
+}
+
 func CreateWriteBuff(writeBuff []byte, exec func(args ...interface{}) (int64, []byte), args ...interface{}) (*LogItem, []byte) {
 	index, buff := exec(args)
 	lItem := NewLogItemBuff(index, buff, writeBuff, true)
-	return lItem, writeBuff[:8+8+len(buff)]
+	return lItem, writeBuff[:LOG_ITEM_HEADER_LENGTH+len(buff)]
 }
 
 func CreateBatchWriteBuff(batchWriteBuff []byte, execs []func(args ...interface{}) (int64, []byte), args ...[]interface{}) ([]*LogItem, []byte) {
@@ -35,7 +63,7 @@ func CreateBatchWriteBuff(batchWriteBuff []byte, execs []func(args ...interface{
 		index, buff := exec(args[i])
 		lItem := NewLogItemBuff(index, buff, batchWriteBuff[pos:], true)
 		lItems[i] = lItem
-		pos = pos + 8 + 8 + len(buff)
+		pos = pos + LOG_ITEM_HEADER_LENGTH + len(buff)
 	}
 	return lItems, batchWriteBuff[:pos]
 }
@@ -55,3 +83,26 @@ func ReadInt64FromBuff(buff []byte, isBigEndian bool) uint64 {
 		return binary.LittleEndian.Uint64(buff)
 	}
 }
+
+//crc32c of the length and index bytes and data, the length is covered,
+//a broken length would read the next log items at a wrong pos
+func LogItemChecksum(headerBuff []byte, data []byte) uint32 {
+	crc := crc32.Update(0, Crc32cTable, headerBuff)
+	return crc32.Update(crc, Crc32cTable, data)
+}
+
+func WriteUint32ToBuff(buff []byte, data uint32, isBigEndian bool) {
+	if isBigEndian {
+		binary.BigEndian.PutUint32(buff, data)
+	} else {
+		binary.LittleEndian.PutUint32(buff, data)
+	}
+}
+
+func ReadUint32FromBuff(buff []byte, isBigEndian bool) uint32 {
+	if isBigEndian {
+		return binary.BigEndian.Uint32(buff)
+	} else {
+		return binary.LittleEndian.Uint32(buff)
+	}
+}